@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// apiVersion is the version of the static/projects.json format described by
+// static/projects.schema.json. Increment it on incompatible changes, i.e.
+// when removing or renaming fields or changing their type. Adding fields is
+// fine.
+const apiVersion = 2
+
+// APIDocument is the root of static/projects.json.
+type APIDocument struct {
+	Version     int
+	GeneratedAt string // RFC 3339
+
+	Projects   []APIProject // all projects including the sheet, sorted by EndDate
+	Videos     []APIVideo   // all videos, sorted by Date
+	News       []APINews    // newest first
+	Organizers []APIOrganizer
+
+	// Stale lists the sources that couldn't be fetched in this run, so
+	// their data is from an earlier run.
+	Stale []APIStaleSource
+}
+
+// APIStaleSource is a source with outdated data.
+type APIStaleSource struct {
+	Source string // "posts", "weekly_updates", "videos" or "sheet"
+	Since  string // RFC 3339, time of the last successful fetch
+}
+
+// APIProject is a project from a Reddit post or, for older projects, from
+// the "All Projects" sheet.
+type APIProject struct {
+	ID         string // Reddit post ID, empty if FromSheet
+	Title      string // plain text
+	Organizer  string
+	URL        string
+	StartDate  string // ISO 8601
+	EndDate    string // ISO 8601
+	IsOfficial bool
+	FromSheet  bool // only known from the sheet, always with ReleasedVideo
+
+	Registers             []string
+	Instruments           []string
+	IsOpenInstrumentation bool
+	Tags                  []string
+
+	LastUpdatePermalink string
+
+	ReleasedVideo *APIVideo
+}
+
+// APIVideo is a YouTube video. The statistics are 0 and Thumbnail is empty
+// if they couldn't be fetched.
+type APIVideo struct {
+	ID    string // YouTube video ID
+	Title string
+	Date  string // RFC 3339, empty for private videos
+	// Sources are the playlist IDs the video was found in, "uploads" for
+	// the uploads of the channel.
+	Sources []string
+
+	Duration     int // seconds
+	ViewCount    uint64
+	LikeCount    uint64
+	CommentCount uint64
+	Thumbnail    string // URL
+}
+
+// APINews is an official news post.
+type APINews struct {
+	ID          string // Reddit post ID
+	Title       string // plain text
+	Author      string
+	Date        string // ISO 8601
+	URL         string
+	Permalink   string
+	NumComments int32
+}
+
+// APIOrganizer is a project organizer with the number of their projects.
+type APIOrganizer struct {
+	Name         string
+	ProjectCount int
+}
+
+func apiVideoFromVideo(v *Video) APIVideo {
+	return APIVideo{
+		ID:           v.ID,
+		Title:        v.Title,
+		Date:         v.Date,
+		Sources:      nonNil(v.Sources),
+		Duration:     v.Duration,
+		ViewCount:    v.ViewCount,
+		LikeCount:    v.LikeCount,
+		CommentCount: v.CommentCount,
+		Thumbnail:    v.Thumbnail,
+	}
+}
+
+// nonNil makes sure that empty lists are encoded as [] instead of null.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// newAPIDocument converts the data collected for the HTML page to the stable
+// API types.
+func newAPIDocument(projects []Project, videos []Video, news []News) *APIDocument {
+	doc := &APIDocument{
+		Version:     apiVersion,
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Projects:    []APIProject{},
+		Videos:      []APIVideo{},
+		News:        []APINews{},
+		Organizers:  []APIOrganizer{},
+		Stale:       []APIStaleSource{},
+	}
+
+	projectCount := make(map[string]int)
+	for _, p := range projects {
+		var instrumentNames []string
+		for _, reg := range p.Registers {
+			for _, instr := range p.InstrumentsByRegister[reg] {
+				instrumentNames = append(instrumentNames, instr.Name)
+			}
+		}
+		ap := APIProject{
+			ID:                    p.ID,
+			Title:                 html.UnescapeString(string(p.Title)),
+			Organizer:             p.Organizer,
+			URL:                   p.URL,
+			StartDate:             p.StartDate,
+			EndDate:               p.EndDate,
+			IsOfficial:            p.IsOfficial,
+			FromSheet:             p.FromSheet,
+			Registers:             nonNil(p.Registers),
+			Instruments:           nonNil(instrumentNames),
+			IsOpenInstrumentation: p.IsOpenInstrumentation,
+			Tags:                  nonNil(p.Tags),
+			LastUpdatePermalink:   p.LastUpdatePermalink,
+		}
+		if p.ReleasedVideo != nil {
+			v := apiVideoFromVideo(p.ReleasedVideo)
+			ap.ReleasedVideo = &v
+		}
+		doc.Projects = append(doc.Projects, ap)
+		projectCount[p.Organizer]++
+	}
+
+	for i := range videos {
+		doc.Videos = append(doc.Videos, apiVideoFromVideo(&videos[i]))
+	}
+
+	for _, n := range news {
+		doc.News = append(doc.News, APINews{
+			ID:          n.ID,
+			Title:       html.UnescapeString(n.Title),
+			Author:      n.Author,
+			Date:        n.Date,
+			URL:         n.URL,
+			Permalink:   n.Permalink,
+			NumComments: n.NumComments,
+		})
+	}
+
+	for name, count := range projectCount {
+		doc.Organizers = append(doc.Organizers, APIOrganizer{Name: name, ProjectCount: count})
+	}
+	sort.Slice(doc.Organizers, func(i, j int) bool {
+		return strings.ToLower(doc.Organizers[i].Name) < strings.ToLower(doc.Organizers[j].Name)
+	})
+
+	return doc
+}
+
+// writeAPIDocument encodes the document as JSON.
+func writeAPIDocument(w io.Writer, doc *APIDocument) error {
+	if err := json.NewEncoder(w).Encode(doc); err != nil {
+		return fmt.Errorf("couldn't encode projects.json: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testAPIDocument returns a document in which every field is set, so that
+// every property of the schema is exercised.
+func testAPIDocument() *APIDocument {
+	video := Video{
+		Title:        "Symphony No. 9",
+		ID:           "dQw4w9WgXcQ",
+		Date:         "2021-03-14T18:00:00Z",
+		Sources:      []string{"PLxyz", uploadsSource},
+		Duration:     754,
+		ViewCount:    1200,
+		LikeCount:    80,
+		CommentCount: 12,
+		Thumbnail:    "https://i.ytimg.com/vi/dQw4w9WgXcQ/hqdefault.jpg",
+	}
+	projects := []Project{
+		{
+			ID:                    "abc123",
+			Title:                 "Dvořák &amp; Friends",
+			Organizer:             "conductor",
+			URL:                   "https://www.reddit.com/r/RedditSymphony/comments/abc123/",
+			StartDate:             "2021-01-01",
+			EndDate:               "2021-02-01",
+			IsOfficial:            true,
+			Registers:             []string{"Strings"},
+			InstrumentsByRegister: map[string][]Instrument{"Strings": {{Register: "Strings", Name: "Cello"}}},
+			Tags:                  []string{"Official"},
+			LastUpdatePermalink:   "https://www.reddit.com/r/RedditSymphony/comments/def456/_/ghi789/",
+			ReleasedVideo:         &video,
+		},
+		{
+			Title:                 "Open Project",
+			Organizer:             "someone",
+			StartDate:             "2021-04-01",
+			EndDate:               "2021-05-01",
+			IsOpenInstrumentation: true,
+		},
+	}
+	news := []News{{
+		ID:          "n1",
+		Title:       "Announcement",
+		Author:      "moderator",
+		Date:        "2021-03-01",
+		URL:         "https://www.reddit.com/r/RedditSymphony/comments/n1/",
+		Permalink:   "/r/RedditSymphony/comments/n1/",
+		NumComments: 3,
+	}}
+	doc := newAPIDocument(projects, []Video{video}, news)
+	doc.Stale = append(doc.Stale, APIStaleSource{"videos", "2021-03-01T12:00:00Z"})
+	return doc
+}
+
+func loadAPISchema(t *testing.T) map[string]interface{} {
+	t.Helper()
+	buf, err := os.ReadFile("static/projects.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(buf, &schema); err != nil {
+		t.Fatalf("couldn't decode schema: %v", err)
+	}
+	return schema
+}
+
+// schemaChecker validates JSON values against the subset of JSON Schema used
+// in static/projects.schema.json. In addition to the standard rules, it
+// reports schema properties that are missing from an object, since all
+// fields of the API types are always encoded.
+type schemaChecker struct {
+	root map[string]interface{}
+}
+
+func (c schemaChecker) check(schema map[string]interface{}, value interface{}, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/definitions/")
+		def, ok := c.root["definitions"].(map[string]interface{})[name].(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: unknown $ref %s", path, ref)}
+		}
+		return c.check(def, value, path)
+	}
+
+	var errs []string
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		matches := 0
+		for _, s := range oneOf {
+			if len(c.check(s.(map[string]interface{}), value, path)) == 0 {
+				matches++
+			}
+		}
+		if matches != 1 {
+			errs = append(errs, fmt.Sprintf("%s: matches %d of oneOf", path, matches))
+		}
+	}
+	if constant, ok := schema["const"]; ok && !reflect.DeepEqual(constant, value) {
+		errs = append(errs, fmt.Sprintf("%s: %v is not %v", path, value, constant))
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || reflect.DeepEqual(e, value)
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s: %v is not in %v", path, value, enum))
+		}
+	}
+	if typ, ok := schema["type"].(string); ok && !hasJSONType(value, typ) {
+		return append(errs, fmt.Sprintf("%s: %v is not of type %s", path, value, typ))
+	}
+
+	switch v := value.(type) {
+	case string:
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(v) {
+			errs = append(errs, fmt.Sprintf("%s: %q doesn't match %s", path, v, pattern))
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %v", path, err))
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				errs = append(errs, c.check(items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case map[string]interface{}:
+		properties, ok := schema["properties"].(map[string]interface{})
+		if !ok {
+			// e.g. a oneOf, which was checked above
+			break
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := v[name.(string)]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: required field %s is missing", path, name))
+			}
+		}
+		for _, name := range sortedKeys(properties) {
+			if _, ok := v[name]; !ok {
+				errs = append(errs, fmt.Sprintf("%s: schema property %s is missing from the document", path, name))
+			}
+		}
+		for _, name := range sortedKeys(v) {
+			s, ok := properties[name].(map[string]interface{})
+			if !ok {
+				errs = append(errs, fmt.Sprintf("%s: field %s is missing from the schema", path, name))
+				continue
+			}
+			errs = append(errs, c.check(s, v[name], path+"."+name)...)
+		}
+	}
+	return errs
+}
+
+func hasJSONType(value interface{}, typ string) bool {
+	switch typ {
+	case "null":
+		return value == nil
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == float64(int64(f))
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	}
+	return false
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestAPIDocumentMatchesSchema(t *testing.T) {
+	schema := loadAPISchema(t)
+	doc := testAPIDocument()
+
+	var buf bytes.Buffer
+	if err := writeAPIDocument(&buf, doc); err != nil {
+		t.Fatal(err)
+	}
+	var value interface{}
+	if err := json.Unmarshal(buf.Bytes(), &value); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, err := range (schemaChecker{schema}).check(schema, value, "projects.json") {
+		t.Error(err)
+	}
+}
+
+// TestAPITypesInSchema checks the Go types directly, so that a field is
+// reported even if the test document doesn't exercise it.
+func TestAPITypesInSchema(t *testing.T) {
+	schema := loadAPISchema(t)
+	definitions := schema["definitions"].(map[string]interface{})
+	types := []struct {
+		typ    reflect.Type
+		schema interface{}
+	}{
+		{reflect.TypeOf(APIDocument{}), schema},
+		{reflect.TypeOf(APIProject{}), definitions["Project"]},
+		{reflect.TypeOf(APIVideo{}), definitions["Video"]},
+		{reflect.TypeOf(APINews{}), definitions["News"]},
+		{reflect.TypeOf(APIOrganizer{}), definitions["Organizer"]},
+		{reflect.TypeOf(APIStaleSource{}), definitions["StaleSource"]},
+	}
+	for _, tt := range types {
+		s, ok := tt.schema.(map[string]interface{})
+		if !ok {
+			t.Errorf("%s: no definition in the schema", tt.typ.Name())
+			continue
+		}
+		properties := s["properties"].(map[string]interface{})
+		fields := make(map[string]bool)
+		for i := 0; i < tt.typ.NumField(); i++ {
+			f := tt.typ.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			fields[f.Name] = true
+			if _, ok := properties[f.Name]; !ok {
+				t.Errorf("%s.%s is missing from the schema", tt.typ.Name(), f.Name)
+			}
+		}
+		for name := range properties {
+			if !fields[name] {
+				t.Errorf("schema property %s is missing from %s", name, tt.typ.Name())
+			}
+		}
+		required, _ := s["required"].([]interface{})
+		for _, name := range required {
+			if !fields[name.(string)] {
+				t.Errorf("required schema property %s is missing from %s", name, tt.typ.Name())
+			}
+		}
+	}
+}
+
+func TestAPIVersionMatchesSchema(t *testing.T) {
+	schema := loadAPISchema(t)
+	version := schema["properties"].(map[string]interface{})["Version"].(map[string]interface{})["const"]
+	if version != float64(apiVersion) {
+		t.Errorf("schema is for version %v, apiVersion is %d", version, apiVersion)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cacheEnvelope wraps the data of the JSON files in data/.
+type cacheEnvelope struct {
+	Schema    int    // version of the format of Data
+	FetchedAt string // RFC 3339
+	Source    string // where the data came from
+	Data      json.RawMessage
+}
+
+// cacheMigration converts data from one schema version to the next.
+type cacheMigration func(data json.RawMessage) (json.RawMessage, error)
+
+// cacheFormat describes a JSON file in data/.
+type cacheFormat struct {
+	source string
+	// migrations[i] converts data from version i to i+1, so the current
+	// version is len(migrations). Version 0 are files from before the
+	// envelope.
+	migrations []cacheMigration
+}
+
+func (f cacheFormat) version() int {
+	return len(f.migrations)
+}
+
+// cacheFormats are the formats of the JSON files in data/. When the format
+// of a file changes, e.g. because an upgrade of graw or the YouTube API
+// renamed fields, append a migration that converts the old data.
+var cacheFormats = map[string]cacheFormat{
+	"posts.json":            {"reddit", []cacheMigration{migrateUnversioned}},
+	"weekly_updates.json":   {"reddit", []cacheMigration{migrateUnversioned}},
+	"videos.json":           {"youtube", []cacheMigration{migrateUnversioned, migrateVideosDetails}},
+	"last_good_render.json": {"render", []cacheMigration{migrateUnversioned}},
+	"feed_extensions.json":  {"render", []cacheMigration{migrateUnversioned}},
+	"diagnostics.json":      {"run", []cacheMigration{migrateUnversioned}},
+}
+
+// migrateUnversioned migrates files from before the envelope. Their data is
+// the same as in version 1.
+func migrateUnversioned(data json.RawMessage) (json.RawMessage, error) {
+	return data, nil
+}
+
+// migrateVideosDetails migrates the list of playlist items to videoCache
+// without details.
+func migrateVideosDetails(data json.RawMessage) (json.RawMessage, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]interface{}{"Items": items, "Details": []interface{}{}})
+}
+
+// writeToCache stores data in data/<name> with the current schema version.
+func writeToCache(dir, name string, data interface{}) error {
+	format, ok := cacheFormats[name]
+	if !ok {
+		return fmt.Errorf("unknown cache file %s", name)
+	}
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("couldn't encode %s: %w", name, err)
+	}
+	envelope := cacheEnvelope{
+		Schema:    format.version(),
+		FetchedAt: time.Now().UTC().Format(time.RFC3339),
+		Source:    format.source,
+		Data:      buf,
+	}
+	return writeFileAtomic(filepath.Join(dir, name), func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(&envelope); err != nil {
+			return fmt.Errorf("couldn't encode %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// loadFromCache loads data/<name> into data, migrating it from older schema
+// versions.
+func loadFromCache(dir, name string, data interface{}) error {
+	fname := filepath.Join(dir, name)
+	envelope, err := readCacheEnvelope(fname)
+	if err != nil {
+		return err
+	}
+	format, ok := cacheFormats[name]
+	if !ok {
+		return fmt.Errorf("unknown cache file %s", fname)
+	}
+	if envelope.Schema < 0 || envelope.Schema > format.version() {
+		return fmt.Errorf("%s has unknown schema version %d (known up to %d), it was probably written by a newer version of rso-projects",
+			fname, envelope.Schema, format.version())
+	}
+	raw := envelope.Data
+	for v := envelope.Schema; v < format.version(); v++ {
+		if raw, err = format.migrations[v](raw); err != nil {
+			return fmt.Errorf("couldn't migrate %s from schema version %d: %w", fname, v, err)
+		}
+	}
+	if err = json.Unmarshal(raw, data); err != nil {
+		return fmt.Errorf("couldn't decode %s: %w", fname, err)
+	}
+	return nil
+}
+
+// readCacheEnvelope reads the envelope of a cache file. Files from before
+// the envelope are returned as schema version 0.
+func readCacheEnvelope(fname string) (*cacheEnvelope, error) {
+	buf, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load %s: %w", fname, err)
+	}
+	var fields map[string]json.RawMessage
+	if bytes.HasPrefix(bytes.TrimSpace(buf), []byte("{")) {
+		if err := json.Unmarshal(buf, &fields); err != nil {
+			return nil, fmt.Errorf("couldn't decode %s: %w", fname, err)
+		}
+	}
+	if _, ok := fields["Schema"]; !ok {
+		return &cacheEnvelope{Schema: 0, Data: buf}, nil
+	}
+	var envelope cacheEnvelope
+	if err := json.Unmarshal(buf, &envelope); err != nil {
+		return nil, fmt.Errorf("couldn't decode %s: %w", fname, err)
+	}
+	return &envelope, nil
+}
+
+// cacheFetchedAt returns the time the data in a cache file was fetched.
+func cacheFetchedAt(fname string) (time.Time, error) {
+	envelope, err := readCacheEnvelope(fname)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if envelope.FetchedAt == "" {
+		return time.Time{}, fmt.Errorf("%s has no fetch time", fname)
+	}
+	return time.Parse(time.RFC3339, envelope.FetchedAt)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/youtube/v3"
+)
+
+func TestReadCacheEnvelope(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		schema   int
+		data     string
+		hasError bool
+	}{
+		{"unversioned list", `[{"ID": "abc"}]`, 0, `[{"ID": "abc"}]`, false},
+		{"unversioned object", `{"LatestVideo": null, "VideoCount": 3, "News": []}`, 0, `{"LatestVideo": null, "VideoCount": 3, "News": []}`, false},
+		{"envelope", `{"Schema": 2, "FetchedAt": "2021-03-31T12:00:00Z", "Source": "youtube", "Data": {"Items": []}}`, 2, `{"Items": []}`, false},
+		{"invalid", `{"Schema": `, 0, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fname := filepath.Join(t.TempDir(), "cache.json")
+			if err := os.WriteFile(fname, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			envelope, err := readCacheEnvelope(fname)
+			if tt.hasError {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if envelope.Schema != tt.schema {
+				t.Errorf("schema = %d, want %d", envelope.Schema, tt.schema)
+			}
+			if string(envelope.Data) != tt.data {
+				t.Errorf("data = %s, want %s", envelope.Data, tt.data)
+			}
+		})
+	}
+}
+
+func TestLoadFromCacheMigrations(t *testing.T) {
+	item := `{"snippet": {"title": "Symphony"}, "contentDetails": {"videoId": "v1"}}`
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		errorMsg string // expected part of the error, empty for success
+	}{
+		{"unversioned list", "videos.json", `[` + item + `]`, ""},
+		{"version 1", "videos.json", `{"Schema": 1, "Data": [` + item + `]}`, ""},
+		{"current version", "videos.json", `{"Schema": 2, "Data": {"Items": [` + item + `], "Details": []}}`, ""},
+		{"newer version", "videos.json", `{"Schema": 3, "Data": {}}`, "unknown schema version 3"},
+		{"invalid migration input", "videos.json", `{"Schema": 1, "Data": {"Items": []}}`, "couldn't migrate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, tt.file), []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			var cached videoCache
+			err := loadFromCache(dir, tt.file, &cached)
+			if tt.errorMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Fatalf("error = %v, want %q", err, tt.errorMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cached.Items) != 1 || cached.Items[0].ContentDetails.VideoId != "v1" {
+				t.Errorf("items = %+v, want video v1", cached.Items)
+			}
+			if cached.Details == nil || len(cached.Details) != 0 {
+				t.Errorf("details = %+v, want empty list", cached.Details)
+			}
+		})
+	}
+}
+
+func TestLoadFromCacheUnversionedObject(t *testing.T) {
+	dir := t.TempDir()
+	content := `{"LatestVideo": {"Title": "Symphony", "ID": "v1"}, "VideoCount": 3, "News": []}`
+	if err := os.WriteFile(filepath.Join(dir, "last_good_render.json"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var last lastGoodRender
+	if err := loadFromCache(dir, "last_good_render.json", &last); err != nil {
+		t.Fatal(err)
+	}
+	if last.LatestVideo == nil || last.LatestVideo.ID != "v1" || last.VideoCount != 3 {
+		t.Errorf("loaded %+v", last)
+	}
+}
+
+func TestCacheRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	want := videoCache{
+		Items:   []youtube.PlaylistItem{{ContentDetails: &youtube.PlaylistItemContentDetails{VideoId: "v1"}}},
+		Details: []youtube.Video{},
+	}
+	if err := writeToCache(dir, "videos.json", want); err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := readCacheEnvelope(filepath.Join(dir, "videos.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Schema != cacheFormats["videos.json"].version() || envelope.Source != "youtube" || envelope.FetchedAt == "" {
+		t.Errorf("envelope = %+v", envelope)
+	}
+	var got videoCache
+	if err := loadFromCache(dir, "videos.json", &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Items, want.Items) {
+		t.Errorf("items = %+v, want %+v", got.Items, want.Items)
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// command is a subcommand of the CLI.
+type command struct {
+	name    string
+	summary string
+	// setup defines the flags of the command on fs and returns a function
+	// that runs the command for the selected communities after parsing the
+	// flags and loading the config. It returns the exit code.
+	setup func(fs *flag.FlagSet) func(configs []*Config) int
+}
+
+var commands []command
+
+func init() {
+	// Initialized here because the help command refers to commands.
+	commands = []command{
+		{"fetch", "fetch data from Reddit, YouTube and Google Sheets and render the site", cmdFetch},
+		{"render", "render the site from the data in data/", cmdRender},
+		{"throwback", "post a random video as throwback on Reddit", cmdThrowback},
+		{"list-projects", "list projects found in data/", cmdListProjects},
+		{"gantt", "print projects as TSV for plot/gantt.gnuplot", cmdGantt},
+		{"diagnose", "report problems with finding project information", cmdDiagnose},
+		{"sql", "run an SQL query on the database and print the result as TSV", cmdSQL},
+		{"serve", "keep running, refresh periodically and optionally serve HTTP", cmdServe},
+		{"help", "show help for a command", cmdHelp},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: rso-projects <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nWithout a command, rso-projects runs fetch.\nRun \"rso-projects help <command>\" for the flags of a command.\n")
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+func newFlagSet(cmd *command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: rso-projects %s [flags]\n\n%s.\n", cmd.name, strings.ToUpper(cmd.summary[:1])+cmd.summary[1:])
+		var hasFlags bool
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintf(os.Stderr, "\nFlags:\n")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// runCommand runs the subcommand given in args and returns the exit code.
+func runCommand(args []string) int {
+	name := "fetch"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage()
+		return 2
+	}
+	fs := newFlagSet(cmd)
+	configPath := fs.String("config", DefaultConfigFile, "JSON config file, settings can be overridden with RSO_* environment variables")
+	community := fs.String("community", "", "only use the community with this name (default all, commands that inspect data use the first)")
+	run := cmd.setup(fs)
+	fs.Parse(args)
+	configs, err := loadConfigs(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *community != "" {
+		config, err := findCommunity(configs, *community)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		configs = []*Config{config}
+	}
+	return run(configs)
+}
+
+func exitCode(failed *Stage) int {
+	if failed != nil {
+		return failed.ExitCode
+	}
+	return 0
+}
+
+func cmdFetch(fs *flag.FlagSet) func([]*Config) int {
+	render := fs.Bool("render", true, "render the site after fetching")
+	return func(configs []*Config) int {
+		return exitCode(runAll(context.Background(), configs, runOptions{render: *render}))
+	}
+}
+
+func cmdRender(fs *flag.FlagSet) func([]*Config) int {
+	at := fs.String("at", "", "render the site as it looked at this date (2021-03-31) or time (RFC 3339) from data/snapshots/, needs -out")
+	out := fs.String("out", "", "render to this directory instead of the static directory")
+	return func(configs []*Config) int {
+		opts := runOptions{cached: true, render: true}
+		if *at != "" {
+			t, err := parseSnapshotTime(*at)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid -at: %s\n", err)
+				return 2
+			}
+			opts.at = t
+			if *out == "" {
+				// Don't publish the old site.
+				fmt.Fprintln(os.Stderr, "-at needs -out, render the snapshot into a separate directory")
+				return 2
+			}
+		}
+		if *out != "" {
+			if len(configs) > 1 {
+				fmt.Fprintln(os.Stderr, "-out needs a single community, select one with -community")
+				return 2
+			}
+			config := *configs[0]
+			config.StaticDir = *out
+			configs = []*Config{&config}
+		}
+		return exitCode(runAll(context.Background(), configs, opts))
+	}
+}
+
+func cmdThrowback(fs *flag.FlagSet) func([]*Config) int {
+	cached := fs.Bool("cached", false, "choose from the videos in data/ instead of fetching and rendering first")
+	return func(configs []*Config) int {
+		return exitCode(runAll(context.Background(), configs, runOptions{cached: *cached, render: !*cached, throwback: true}))
+	}
+}
+
+func cmdServe(fs *flag.FlagSet) func([]*Config) int {
+	cached := fs.Bool("cached", false, "render from data/ instead of fetching")
+	interval := fs.Duration("interval", time.Hour, "time between refreshes")
+	throwback := fs.Bool("throwback", false, "post a throwback link every week")
+	throwbackAt := fs.String("throwback-at", "Thursday 17:00", "weekly throwback time (UTC)")
+	httpAddr := fs.String("http", "", "serve static/, /metrics and /admin/* on this address, e.g. :8080")
+	return func(configs []*Config) int {
+		var at *weeklyTime
+		if *throwback {
+			t, err := parseWeeklyTime(*throwbackAt)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid -throwback-at: %s\n", err)
+				return 2
+			}
+			at = &t
+		}
+		serve(configs, runOptions{cached: *cached, render: true}, *interval, at, *httpAddr)
+		return 0
+	}
+}
+
+// loadCachedClient loads data/ for the commands that only inspect data.
+func loadCachedClient(config *Config) (*DataClient, bool) {
+	client := NewDataClient(config)
+	if err := client.LoadFromCache(); err != nil {
+		fmt.Fprintf(os.Stderr, "couldn't load from cache: %s\n", err)
+		return nil, false
+	}
+	return client, true
+}
+
+func cmdListProjects(fs *flag.FlagSet) func([]*Config) int {
+	asJSON := fs.Bool("json", false, "print JSON in the format of projects.json")
+	active := fs.Bool("active", false, "only list active projects")
+	return func(configs []*Config) int {
+		return listProjects(configs[0], *asJSON, *active)
+	}
+}
+
+func listProjects(config *Config, asJSON, active bool) int {
+	client, ok := loadCachedClient(config)
+	if !ok {
+		return stageLoadCache.ExitCode
+	}
+	projects, activeProjects := findProjects(config, client)
+	if active {
+		projects = activeProjects
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(newAPIDocument(projects, nil, nil).Projects); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "START\tDEADLINE\tORGANIZER\tTITLE\tINSTRUMENTS")
+	for _, p := range projects {
+		instruments := "open"
+		if !p.IsOpenInstrumentation {
+			var names []string
+			for _, reg := range p.Registers {
+				for _, instr := range p.InstrumentsByRegister[reg] {
+					names = append(names, instr.Name)
+				}
+			}
+			instruments = strings.Join(names, ", ")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.StartDate, p.EndDate, p.Organizer, html.UnescapeString(string(p.Title)), instruments)
+	}
+	w.Flush()
+	return 0
+}
+
+func cmdGantt(fs *flag.FlagSet) func([]*Config) int {
+	return func(configs []*Config) int {
+		config := configs[0]
+		client, ok := loadCachedClient(config)
+		if !ok {
+			return stageLoadCache.ExitCode
+		}
+		projects, _ := findProjects(config, client)
+		for _, p := range projects {
+			fmt.Printf("%s\t%s\t%s\n", html.UnescapeString(string(p.Title)), p.StartDate, p.EndDate)
+		}
+		return 0
+	}
+}
+
+func cmdDiagnose(fs *flag.FlagSet) func([]*Config) int {
+	return func(configs []*Config) int {
+		config := configs[0]
+		client, ok := loadCachedClient(config)
+		if !ok {
+			return stageLoadCache.ExitCode
+		}
+		diagnoseProjects(config, client)
+		diagnoseSheet(config, client)
+		diagnoseVideos(config, client)
+		diagnoseCache(config)
+
+		var last Diagnostics
+		if err := loadFromCache(config.DataDir, "diagnostics.json", &last); err != nil {
+			fmt.Printf("no diagnostics from the last run: %s\n", err)
+		} else {
+			fmt.Printf("\n%d warnings in the last run:\n", len(last.Warnings))
+			for _, d := range last.Warnings {
+				fmt.Printf("  %s: %s\n", d.Time, d.Message)
+			}
+		}
+		return 0
+	}
+}
+
+// diagnoseProjects prints project posts for which information couldn't be
+// found.
+func diagnoseProjects(config *Config, client *DataClient) {
+	fmt.Println("Project posts:")
+	for _, post := range client.Posts {
+		if !config.isProject(&post) {
+			continue
+		}
+		var problems []string
+		deadline := findDeadline(post.SelfText, int64(post.CreatedUTC))
+		if deadline.IsZero() {
+			problems = append(problems, "no deadline found, project is hidden")
+		}
+		if !isOpenInstrumentation(post.SelfText) && len(findInstruments(post.SelfText)) == 0 {
+			problems = append(problems, "no instruments found")
+		}
+		if findUpdateComment(&post, client.WeeklyUpdates) == nil {
+			problems = append(problems, "no weekly update comment")
+		}
+		if !deadline.IsZero() && time.Since(deadline) > 90*24*time.Hour && findMatchingVideo(&post, client.Videos, deadline) == nil {
+			problems = append(problems, "no released video found")
+		}
+		status := "ok"
+		if len(problems) > 0 {
+			status = strings.Join(problems, "; ")
+		}
+		fmt.Printf("  %s (%s): %s\n", html.UnescapeString(post.Title), post.ID, status)
+	}
+}
+
+// diagnoseSheet prints rows of the sheet that couldn't be parsed.
+func diagnoseSheet(config *Config, client *DataClient) {
+	if client.Sheet == nil {
+		return
+	}
+	fmt.Println("\nSheet:")
+	projects, rowErrs, err := parseSheet(client.Sheet, config.SheetCreatorAliases)
+	if err != nil {
+		fmt.Printf("  %s\n", err)
+		return
+	}
+	fmt.Printf("  %d projects\n", len(projects))
+	for _, err := range rowErrs {
+		fmt.Printf("  %s\n", err)
+	}
+}
+
+// diagnoseVideos prints uploads of the channel that are missing from the
+// main playlist.
+func diagnoseVideos(config *Config, client *DataClient) {
+	if config.ChannelID == "" {
+		return
+	}
+	missing := client.videosMissingFromPlaylist()
+	fmt.Printf("\nChannel videos missing from playlist %s: %d\n", config.PlaylistID, len(missing))
+	for _, v := range missing {
+		fmt.Printf("  %s (%s), published %s\n", v.Title, v.ID, v.Date)
+	}
+}
+
+// diagnoseCache prints the schema version and fetch time of the files in
+// data/.
+func diagnoseCache(config *Config) {
+	fmt.Println("\nCache files:")
+	var names []string
+	for name := range cacheFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		format := cacheFormats[name]
+		envelope, err := readCacheEnvelope(filepath.Join(config.DataDir, name))
+		switch {
+		case err != nil:
+			fmt.Printf("  %s\n", err)
+		case envelope.Schema == 0:
+			fmt.Printf("  %s: no schema version, migrated to %d when loading\n", name, format.version())
+		case envelope.Schema != format.version():
+			fmt.Printf("  %s: schema version %d (current %d), from %s at %s\n", name, envelope.Schema, format.version(), envelope.Source, envelope.FetchedAt)
+		default:
+			fmt.Printf("  %s: from %s at %s\n", name, envelope.Source, envelope.FetchedAt)
+		}
+	}
+}
+
+func cmdSQL(fs *flag.FlagSet) func([]*Config) int {
+	return func(configs []*Config) int {
+		config := configs[0]
+		if config.Database == "" {
+			fmt.Fprintln(os.Stderr, "no database configured, set Database in the config")
+			return 2
+		}
+		if fs.NArg() != 1 {
+			fmt.Fprintln(os.Stderr, "usage: rso-projects sql <query>")
+			return 2
+		}
+		store, err := openStore(config.Database)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer store.Close()
+		if err := store.Query(os.Stdout, fs.Arg(0)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+}
+
+func cmdHelp(fs *flag.FlagSet) func([]*Config) int {
+	return func([]*Config) int {
+		if fs.NArg() == 0 {
+			usage()
+			return 0
+		}
+		cmd := findCommand(fs.Arg(0))
+		if cmd == nil {
+			fmt.Fprintf(os.Stderr, "unknown command %q\n\n", fs.Arg(0))
+			usage()
+			return 2
+		}
+		// The command prints its usage and exits.
+		return runCommand([]string{cmd.name, "-h"})
+	}
+}
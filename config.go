@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/turnage/graw/reddit"
+)
+
+// Config holds everything specific to the community and the installation.
+// The defaults are for r/TheRedditSymphony.
+type Config struct {
+	Name               string // short name for logs and metrics
+	Title              string // e.g. of the Atom feed
+	ShortTitle         string // e.g. of the calendars
+	Subreddit          string // without r/
+	PlaylistID         string // YouTube playlist with all released videos
+	AllProjectsSheetID string // Google Sheet with the "All Projects" table
+	SiteURL            string // with trailing slash
+
+	// Videos are also fetched from these playlists and, if ChannelID is
+	// set, from the uploads of that channel, e.g. for videos that are
+	// missing from PlaylistID.
+	ExtraPlaylistIDs []string
+	ChannelID        string
+
+	ProjectFlairs        []string // flairs of project posts
+	OfficialProjectFlair string   // flair of projects by the moderators
+	NewsFlair            string   // flair of official news posts
+	ThrowbackFlair       string
+	ThrowbackAuthor      string // account posting throwbacks
+	WeeklyUpdateQuery    string // Reddit search for the weekly update threads
+	ModeratorBot         string // author of automatic posts, excluded from news
+
+	// SheetCreatorAliases maps names in the Creator column of the sheet
+	// to Reddit users.
+	SheetCreatorAliases map[string]string
+
+	// SnapshotDays is how long snapshots of the fetched data are kept in
+	// data/snapshots/ for render -at, 0 disables them.
+	SnapshotDays int
+
+	// Database is the SQLite database storing fetched records and derived
+	// projects, empty to only use the files in DataDir.
+	Database string
+
+	AgentFile string // graw agent file with Reddit credentials
+	DataDir   string
+	StaticDir string
+	Template  string
+}
+
+// DefaultConfigFile is loaded if it exists and no other file is given.
+const DefaultConfigFile = "config.json"
+
+func defaultConfig() *Config {
+	return &Config{
+		Name:               "rso",
+		Title:              "The Reddit Symphony Orchestra",
+		ShortTitle:         "RSO",
+		Subreddit:          "TheRedditSymphony",
+		PlaylistID:         "PLAl3fvW4KndiZAQtPmFCUFD6nImDC89Gv",
+		AllProjectsSheetID: "12njIGc2_G4uMJ8uvfq1uKvdFfzopRYdhCdRdfo3e7Hg",
+		SiteURL:            "https://www.rso-music.com/",
+
+		ProjectFlairs:        []string{"Approved Project", "Official Project"},
+		OfficialProjectFlair: "Official Project",
+		NewsFlair:            "Official",
+		ThrowbackFlair:       "Throwback Thursday",
+		ThrowbackAuthor:      "rso-throwback",
+		WeeklyUpdateQuery:    "Weekly Project Update Thread author:AutoModerator",
+		ModeratorBot:         "AutoModerator",
+
+		SheetCreatorAliases: map[string]string{"The Reddit Symphony Orchestra": "CasuallyNothing"},
+
+		SnapshotDays: 365,
+
+		AgentFile: "agentfile",
+		DataDir:   "data",
+		StaticDir: "static",
+		Template:  "template.html",
+	}
+}
+
+// configFile is the format of the config file: the settings of a single
+// community, optionally followed by a list of communities. Each community
+// starts with the settings at the top level and overrides some of them.
+type configFile struct {
+	*Config
+	Communities []json.RawMessage
+}
+
+// loadConfigs reads the JSON config file at path over the defaults and
+// applies overrides from RSO_* environment variables. It returns one config
+// per community. A missing file is fine if path is the default.
+func loadConfigs(path string) ([]*Config, error) {
+	file := configFile{Config: defaultConfig()}
+
+	f, err := os.Open(path)
+	switch {
+	case err == nil:
+		defer f.Close()
+		decoder := json.NewDecoder(f)
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(&file); err != nil {
+			return nil, fmt.Errorf("couldn't decode %s: %w", path, err)
+		}
+	case errors.Is(err, os.ErrNotExist) && path == DefaultConfigFile:
+		// Use defaults.
+	default:
+		return nil, fmt.Errorf("couldn't load config: %w", err)
+	}
+
+	// Environment variables apply to the top level, so they don't override
+	// the directories of all communities at once.
+	file.Config.applyEnv()
+	if len(file.Communities) == 0 {
+		return []*Config{file.Config}, nil
+	}
+
+	var configs []*Config
+	for i, raw := range file.Communities {
+		c := *file.Config
+		c.ProjectFlairs = append([]string(nil), c.ProjectFlairs...)
+		c.ExtraPlaylistIDs = append([]string(nil), c.ExtraPlaylistIDs...)
+		c.SheetCreatorAliases = make(map[string]string)
+		for k, v := range file.Config.SheetCreatorAliases {
+			c.SheetCreatorAliases[k] = v
+		}
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&c); err != nil {
+			return nil, fmt.Errorf("couldn't decode community %d in %s: %w", i+1, path, err)
+		}
+		configs = append(configs, &c)
+	}
+	if err := checkCommunities(configs); err != nil {
+		return nil, fmt.Errorf("invalid communities in %s: %w", path, err)
+	}
+	return configs, nil
+}
+
+// checkCommunities makes sure that communities don't overwrite each other's
+// files.
+func checkCommunities(configs []*Config) error {
+	names := make(map[string]bool)
+	dirs := make(map[string]string)
+	for _, c := range configs {
+		if c.Name == "" || names[c.Name] {
+			return fmt.Errorf("community names must be unique and non-empty, got %q", c.Name)
+		}
+		names[c.Name] = true
+		for _, dir := range []string{c.DataDir, c.StaticDir, c.Database} {
+			if dir == "" {
+				continue
+			}
+			dir = filepath.Clean(dir)
+			if other, ok := dirs[dir]; ok {
+				return fmt.Errorf("communities %s and %s both use %s", other, c.Name, dir)
+			}
+			dirs[dir] = c.Name
+		}
+	}
+	return nil
+}
+
+// findCommunity returns the config of the community with the given name, or
+// the first community if name is empty.
+func findCommunity(configs []*Config, name string) (*Config, error) {
+	if name == "" {
+		return configs[0], nil
+	}
+	for _, c := range configs {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown community %q", name)
+}
+
+// applyEnv overrides settings from environment variables, e.g. RSO_STATIC_DIR
+// for StaticDir. Lists are separated by commas.
+func (c *Config) applyEnv() {
+	strs := map[string]*string{
+		"RSO_NAME":                   &c.Name,
+		"RSO_TITLE":                  &c.Title,
+		"RSO_SHORT_TITLE":            &c.ShortTitle,
+		"RSO_SUBREDDIT":              &c.Subreddit,
+		"RSO_PLAYLIST_ID":            &c.PlaylistID,
+		"RSO_CHANNEL_ID":             &c.ChannelID,
+		"RSO_ALL_PROJECTS_SHEET_ID":  &c.AllProjectsSheetID,
+		"RSO_SITE_URL":               &c.SiteURL,
+		"RSO_OFFICIAL_PROJECT_FLAIR": &c.OfficialProjectFlair,
+		"RSO_NEWS_FLAIR":             &c.NewsFlair,
+		"RSO_THROWBACK_FLAIR":        &c.ThrowbackFlair,
+		"RSO_THROWBACK_AUTHOR":       &c.ThrowbackAuthor,
+		"RSO_WEEKLY_UPDATE_QUERY":    &c.WeeklyUpdateQuery,
+		"RSO_MODERATOR_BOT":          &c.ModeratorBot,
+		"RSO_AGENT_FILE":             &c.AgentFile,
+		"RSO_DATA_DIR":               &c.DataDir,
+		"RSO_STATIC_DIR":             &c.StaticDir,
+		"RSO_TEMPLATE":               &c.Template,
+		"RSO_DATABASE":               &c.Database,
+	}
+	for env, field := range strs {
+		if v, ok := os.LookupEnv(env); ok {
+			*field = v
+		}
+	}
+	if v, ok := os.LookupEnv("RSO_PROJECT_FLAIRS"); ok {
+		c.ProjectFlairs = strings.Split(v, ",")
+	}
+	if v, ok := os.LookupEnv("RSO_EXTRA_PLAYLIST_IDS"); ok {
+		c.ExtraPlaylistIDs = strings.Split(v, ",")
+	}
+}
+
+// isProject identifies projects by their flair.
+func (c *Config) isProject(post *reddit.Post) bool {
+	for _, flair := range c.ProjectFlairs {
+		if post.LinkFlairText == flair {
+			return true
+		}
+	}
+	return false
+}
+
+// isNews identifies official news posts, skipping automatic posts like the
+// weekly update threads.
+func (c *Config) isNews(post *reddit.Post) bool {
+	return post.LinkFlairText == c.NewsFlair && post.Author != c.ModeratorBot
+}
+
+// subredditPath returns the API path of a subreddit page, e.g. "search".
+func (c *Config) subredditPath(page string) string {
+	return "/r/" + c.Subreddit + "/" + page
+}
+
+// siteHost returns the host of SiteURL without "www.", e.g. for calendar
+// UIDs. It falls back to Name if SiteURL is invalid.
+func (c *Config) siteHost() string {
+	u, err := url.Parse(c.SiteURL)
+	if err != nil || u.Hostname() == "" {
+		return c.Name
+	}
+	return strings.TrimPrefix(u.Hostname(), "www.")
+}
+
+// feedIDPrefix returns the prefix of all Atom IDs of the community, e.g.
+// "tag:rso-music.com,2020:". It must never change, otherwise feed readers
+// will show all entries again. Sites in a subdirectory include it, e.g.
+// "tag:example.com,2020:choir/".
+func (c *Config) feedIDPrefix() string {
+	prefix := "tag:" + c.siteHost() + ",2020:"
+	if u, err := url.Parse(c.SiteURL); err == nil {
+		prefix += strings.TrimPrefix(u.Path, "/")
+	}
+	return prefix
+}
+
+// staticFile returns the path of a file in the static directory.
+func (c *Config) staticFile(name string) string {
+	return filepath.Join(c.StaticDir, name)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// weeklyTime is a point in time that repeats every week, in UTC.
+type weeklyTime struct {
+	Weekday time.Weekday
+	Hour    int
+	Minute  int
+}
+
+// parseWeeklyTime parses a time like "Thursday 17:00".
+func parseWeeklyTime(s string) (weeklyTime, error) {
+	var t weeklyTime
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return t, fmt.Errorf(`expected "<weekday> <hh:mm>", got %q`, s)
+	}
+	weekday := -1
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), fields[0]) {
+			weekday = int(d)
+		}
+	}
+	if weekday < 0 {
+		return t, fmt.Errorf("invalid weekday %q", fields[0])
+	}
+	clock, err := time.Parse("15:04", fields[1])
+	if err != nil {
+		return t, fmt.Errorf("invalid time %q", fields[1])
+	}
+	t.Weekday = time.Weekday(weekday)
+	t.Hour = clock.Hour()
+	t.Minute = clock.Minute()
+	return t, nil
+}
+
+// Next returns the first occurrence after now.
+func (t weeklyTime) Next(now time.Time) time.Time {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), t.Hour, t.Minute, 0, 0, time.UTC)
+	next = next.AddDate(0, 0, int(t.Weekday-next.Weekday()+7)%7)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next
+}
+
+// serve runs all communities with opts every interval and posts a throwback
+// at throwbackAt (if not nil) until it receives SIGTERM or SIGINT, which also
+// cancel a running fetch. SIGHUP triggers an immediate refresh. Runs never
+// overlap: SIGHUP and timers are handled between runs. If httpAddr is not
+// empty, it also serves the sites via HTTP, including an endpoint to request
+// a refresh.
+func serve(configs []*Config, opts runOptions, interval time.Duration, throwbackAt *weeklyTime, httpAddr string) {
+	throwbackOpts := opts
+	throwbackOpts.throwback = true
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ref := newRefresher()
+	if httpAddr != "" {
+		srv := startHTTPServer(configs, httpAddr, ref)
+		defer stopHTTPServer(srv)
+	}
+
+	refresh := time.NewTimer(0)
+	defer refresh.Stop()
+
+	// refreshNow runs immediately and restarts the interval.
+	refreshNow := func() {
+		ref.run(func() { runScheduled(ctx, configs, opts) })
+		if !refresh.Stop() {
+			<-refresh.C
+		}
+		refresh.Reset(interval)
+	}
+
+	// throwback stays nil (blocking forever) if throwbacks are disabled.
+	var throwback <-chan time.Time
+	if throwbackAt != nil {
+		next := throwbackAt.Next(time.Now())
+		fmt.Printf("next throwback at %s\n", next.Format(time.RFC3339))
+		throwback = time.After(time.Until(next))
+	}
+
+	for {
+		select {
+		case <-refresh.C:
+			ref.run(func() { runScheduled(ctx, configs, opts) })
+			refresh.Reset(interval)
+		case <-ref.requests:
+			fmt.Println("refresh requested")
+			refreshNow()
+		case <-throwback:
+			ref.run(func() { runScheduled(ctx, configs, throwbackOpts) })
+			next := throwbackAt.Next(time.Now())
+			fmt.Printf("next throwback at %s\n", next.Format(time.RFC3339))
+			throwback = time.After(time.Until(next))
+		case <-hup:
+			fmt.Println("received SIGHUP, refreshing")
+			refreshNow()
+		case <-ctx.Done():
+			fmt.Println("received signal, exiting")
+			return
+		}
+	}
+}
+
+// runScheduled does a single run of all communities in daemon mode.
+func runScheduled(ctx context.Context, configs []*Config, opts runOptions) {
+	start := time.Now()
+	if failed := runAll(ctx, configs, opts); failed != nil {
+		fmt.Printf("run failed in stage %s after %s\n", failed.Name, time.Since(start).Round(time.Millisecond))
+		return
+	}
+	fmt.Printf("run finished after %s\n", time.Since(start).Round(time.Millisecond))
+}
@@ -2,77 +2,336 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"encoding/csv"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"path/filepath"
 	"sort"
-	"time"
-	"net/http"
-	"io"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/turnage/graw/reddit"
+	"github.com/turnage/redditproto"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/googleapi/transport"
 	"google.golang.org/api/option"
 	"google.golang.org/api/youtube/v3"
 )
 
-var rsoPlaylistID = "PLAl3fvW4KndiZAQtPmFCUFD6nImDC89Gv"
-var allProjectsDoc = "12njIGc2_G4uMJ8uvfq1uKvdFfzopRYdhCdRdfo3e7Hg"
-
 // DataClient fetches RSO posts and comments from reddit and videos from  YouTube.
 type DataClient struct {
+	config  *Config
 	bot     reddit.Bot
 	youtube *youtube.Service
 
 	Posts         []reddit.Post
 	WeeklyUpdates []reddit.Comment
 	Videos        []youtube.PlaylistItem
+	// VideoDetails are the statistics and thumbnails of Videos. They may
+	// be missing for some videos, e.g. private ones.
+	VideoDetails []youtube.Video
+	// VideoSources are the playlists each video was found in by video ID,
+	// "uploads" for the uploads of the channel.
+	VideoSources map[string][]string
+	// Sheet are the rows of the "All Projects" table, starting with the
+	// header row.
+	Sheet [][]string
+
+	Diagnostics Diagnostics
+	Stale       []StaleSource // sources loaded from the cache after failing
+
+	// At is the time of the snapshot the data was loaded from, zero for
+	// current data.
+	At time.Time
+}
+
+// now returns the current time as seen by the data.
+func (c *DataClient) now() time.Time {
+	if !c.At.IsZero() {
+		return c.At
+	}
+	return time.Now()
+}
+
+// StaleSource is a source that couldn't be fetched, so its data is from the
+// last successful fetch.
+type StaleSource struct {
+	Name  string
+	Since time.Time // time of the last successful fetch
+	Error string
+}
+
+var sourceLabels = map[string]string{
+	"posts":          "Reddit posts",
+	"weekly_updates": "weekly updates",
+	"videos":         "YouTube videos",
+	"sheet":          "all projects sheet",
+}
+
+// Label returns a human-readable name of the source.
+func (s StaleSource) Label() string {
+	return sourceLabels[s.Name]
+}
+
+// source is one kind of data with its own cache file.
+type source struct {
+	name  string
+	stage Stage
+	fetch func(ctx context.Context) error
+	file  string       // path of the cached copy
+	load  func() error // loads the cached copy
+
+	// Sources of the same group are fetched one after the other, different
+	// groups concurrently.
+	group   string
+	timeout time.Duration // for fetching, including retries
+}
+
+// allSources are the names of the sources in the order they are fetched.
+var allSources = []string{"posts", "weekly_updates", "videos", "sheet"}
+
+func (c *DataClient) sources() []source {
+	dataFile := func(name string) string { return filepath.Join(c.config.DataDir, name) }
+	// Reddit requests are limited to one per second anyway, and graw's
+	// client isn't safe for concurrent requests.
+	return []source{
+		{"posts", stageFetchPosts, c.FetchPosts, dataFile("posts.json"), func() error {
+			return loadFromCache(c.config.DataDir, "posts.json", &c.Posts)
+		}, "reddit", time.Minute},
+		{"weekly_updates", stageFetchWeeklyUpdates, c.FetchWeeklyUpdates, dataFile("weekly_updates.json"), func() error {
+			return loadFromCache(c.config.DataDir, "weekly_updates.json", &c.WeeklyUpdates)
+		}, "reddit", 5 * time.Minute},
+		{"videos", stageFetchVideos, c.FetchVideos, dataFile("videos.json"), c.loadVideos, "youtube", 5 * time.Minute},
+		{"sheet", stageFetchSheet, c.FetchAllProjectsSheet, dataFile("allprojects.csv"), func() error {
+			return c.loadSheet()
+		}, "sheets", time.Minute},
+	}
+}
+
+// fallBackToCache loads the cached copy of a source that couldn't be fetched
+// and marks it as stale. The time of the copy is its fetch time or, for files
+// without one, the modification time.
+func (c *DataClient) fallBackToCache(s source, fetchErr error) error {
+	info, err := os.Stat(s.file)
+	if err != nil {
+		return fmt.Errorf("no cached %s to fall back to: %w", s.name, err)
+	}
+	if err := s.load(); err != nil {
+		return fmt.Errorf("falling back to cached %s failed: %w", s.name, err)
+	}
+	since := info.ModTime()
+	if t, err := cacheFetchedAt(s.file); err == nil {
+		since = t
+	}
+	c.Stale = append(c.Stale, StaleSource{Name: s.name, Since: since, Error: fetchErr.Error()})
+	c.Diagnostics.Warnf("using cached %s from %s: %s", s.name, since.UTC().Format(time.RFC3339), fetchErr)
+	return nil
 }
 
 // NewDataClient creates a new, unitialized client.
-func NewDataClient() *DataClient {
-	return &DataClient{}
+func NewDataClient(config *Config) *DataClient {
+	return &DataClient{config: config}
+}
+
+// sharedClients are the API clients of all communities. Sharing the reddit
+// bot means that all communities are subject to the same rate limit.
+var sharedClients struct {
+	mu      sync.Mutex
+	bots    map[string]reddit.Bot // by agent file
+	youtube *youtube.Service
 }
 
-// Init reads auth data from "agentfile" to initialize a reddit
+// Init reads auth data from the agent file to initialize a reddit
 // client and an API key from the YOUTUBE_API_KEY environment variable to
-// create a YouTube client.
+// create a YouTube client. Clients are created once and shared by all
+// communities.
 func (c *DataClient) Init() error {
-	var err error
-	c.bot, err = reddit.NewBotFromAgentFile("agentfile", 1*time.Second)
-	if err != nil {
-		return fmt.Errorf("creating reddit bot failed: %w", err)
+	sharedClients.mu.Lock()
+	defer sharedClients.mu.Unlock()
+
+	bot, ok := sharedClients.bots[c.config.AgentFile]
+	if !ok {
+		var err error
+		bot, err = newRedditBot(c.config.AgentFile)
+		if err != nil {
+			return fmt.Errorf("creating reddit bot failed: %w", err)
+		}
+		if sharedClients.bots == nil {
+			sharedClients.bots = make(map[string]reddit.Bot)
+		}
+		sharedClients.bots[c.config.AgentFile] = bot
 	}
+	c.bot = bot
 
-	c.youtube, err = youtube.NewService(context.TODO(), option.WithAPIKey(os.Getenv("YOUTUBE_API_KEY")))
-	if err != nil {
-		return fmt.Errorf("creating YouTube client failed: %w", err)
+	if sharedClients.youtube == nil {
+		// The API key is added by a transport as option.WithAPIKey is
+		// ignored with a custom HTTP client.
+		yt, err := youtube.NewService(context.Background(), option.WithHTTPClient(&http.Client{
+			Transport: &transport.APIKey{Key: os.Getenv("YOUTUBE_API_KEY"), Transport: apiTransport},
+		}))
+		if err != nil {
+			return fmt.Errorf("creating YouTube client failed: %w", err)
+		}
+		sharedClients.youtube = yt
 	}
+	c.youtube = sharedClients.youtube
 	return nil
 }
 
-// LoadFromCache populates posts and comments from data/*.json
+// newRedditBot creates a bot from a graw agent file that sends its requests
+// through apiTransport.
+func newRedditBot(agentFile string) (reddit.Bot, error) {
+	buf, err := os.ReadFile(agentFile)
+	if err != nil {
+		return nil, err
+	}
+	var agent redditproto.UserAgent
+	if err := proto.UnmarshalText(string(buf), &agent); err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %w", agentFile, err)
+	}
+	return reddit.NewBot(reddit.BotConfig{
+		Agent: agent.GetUserAgent(),
+		App: reddit.App{
+			ID:       agent.GetClientId(),
+			Secret:   agent.GetClientSecret(),
+			Username: agent.GetUsername(),
+			Password: agent.GetPassword(),
+		},
+		Rate:   time.Second,
+		Client: newAPIClient(),
+	})
+}
+
+// LoadFromCache populates posts and comments from data/*.json or, if
+// configured, from the latest run in the database.
 func (c *DataClient) LoadFromCache() error {
-	if err := loadFromCache("posts.json", &c.Posts); err != nil {
+	if c.config.Database != "" {
+		return c.LoadFromStore()
+	}
+	if err := loadFromCache(c.config.DataDir, "posts.json", &c.Posts); err != nil {
 		return err
 	}
-	if err := loadFromCache("weekly_updates.json", &c.WeeklyUpdates); err != nil {
+	if err := loadFromCache(c.config.DataDir, "weekly_updates.json", &c.WeeklyUpdates); err != nil {
 		return err
 	}
-	if err := loadFromCache("videos.json", &c.Videos); err != nil {
+	if err := c.loadVideos(); err != nil {
+		return err
+	}
+	// Older data directories don't have the sheet yet.
+	if err := c.loadSheet(); err != nil {
+		c.Diagnostics.Warnf("no cached sheet: %s", err)
+	}
+	return nil
+}
+
+// videoCache is the format of data/videos.json.
+type videoCache struct {
+	Items   []youtube.PlaylistItem // from the playlists, without duplicates
+	Details []youtube.Video        // from videos.list
+	Sources map[string][]string    // missing in older files
+
+	// Playlists are used to detect unchanged playlists, by playlist ID.
+	Playlists map[string]cachedPlaylist
+}
+
+// cachedPlaylist is the state of a playlist at the last time all of its
+// pages were fetched.
+type cachedPlaylist struct {
+	ETag      string // of the first page
+	Count     int64  // total number of items
+	VideoIDs  []string
+	FetchedAt time.Time
+}
+
+// playlistMaxAge is how long an unchanged playlist is served from the cache.
+// ETags only change with the first page, so e.g. an edited title on a later
+// page is picked up after this time.
+const playlistMaxAge = 24 * time.Hour
+
+// items returns the cached items of a playlist, if all of them are cached.
+func (vc *videoCache) items(p cachedPlaylist) ([]youtube.PlaylistItem, bool) {
+	byID := make(map[string]*youtube.PlaylistItem)
+	for i := range vc.Items {
+		byID[vc.Items[i].ContentDetails.VideoId] = &vc.Items[i]
+	}
+	var items []youtube.PlaylistItem
+	for _, id := range p.VideoIDs {
+		item, ok := byID[id]
+		if !ok {
+			return nil, false
+		}
+		items = append(items, *item)
+	}
+	return items, true
+}
+
+// loadVideos loads the videos and their details from data/videos.json.
+func (c *DataClient) loadVideos() error {
+	var cached videoCache
+	if err := loadFromCache(c.config.DataDir, "videos.json", &cached); err != nil {
 		return err
 	}
+	c.Videos = cached.Items
+	c.VideoDetails = cached.Details
+	c.VideoSources = cached.Sources
+	return nil
+}
+
+// loadSheet loads the sheet from data/allprojects.csv.
+func (c *DataClient) loadSheet() error {
+	fname := filepath.Join(c.config.DataDir, "allprojects.csv")
+	f, err := os.Open(fname)
+	if err != nil {
+		return fmt.Errorf("couldn't load %s: %w", fname, err)
+	}
+	defer f.Close()
+	rows, err := parseSheetCSV(f)
+	if err != nil {
+		return fmt.Errorf("couldn't parse %s: %w", fname, err)
+	}
+	c.Sheet = rows
+	return nil
+}
+
+// parseSheetCSV parses the CSV export of the sheet. Rows may have different
+// lengths.
+func parseSheetCSV(r io.Reader) ([][]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	return reader.ReadAll()
+}
+
+// writeSheetCSV writes the rows of the sheet as CSV.
+func writeSheetCSV(w io.Writer, rows [][]string) error {
+	writer := csv.NewWriter(w)
+	if err := writer.WriteAll(rows); err != nil {
+		return fmt.Errorf("couldn't write sheet CSV: %w", err)
+	}
 	return nil
 }
 
-// FetchPosts fetches the latest Approved Projects, Official Projects and
-// Official posts from Reddit.
-func (c *DataClient) FetchPosts() error {
-	results, err := c.bot.ListingWithParams("/r/TheRedditSymphony/search", map[string]string{
+// FetchPosts fetches the latest posts with project and news flairs from
+// Reddit. As graw doesn't support contexts, ctx is only checked before the
+// request; the request itself is limited by attemptTimeout.
+func (c *DataClient) FetchPosts(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("fetching posts failed: %w", err)
+	}
+	var query []string
+	// Copy the flairs, the config is shared with concurrent fetches.
+	flairs := append(append([]string{}, c.config.ProjectFlairs...), c.config.NewsFlair)
+	for _, flair := range flairs {
+		query = append(query, fmt.Sprintf("flair:%q", flair))
+	}
+	results, err := c.bot.ListingWithParams(c.config.subredditPath("search"), map[string]string{
 		"restrict_sr": "1",
 		"sort":        "new",
 		"limit":       "100",
-		"q":           "flair:\"Approved Project\" OR flair:\"Official Project\" OR flair:\"Official\"",
+		"q":           strings.Join(query, " OR "),
 	})
 	if err != nil {
 		return fmt.Errorf("fetching posts failed: %w", err)
@@ -86,125 +345,382 @@ func (c *DataClient) FetchPosts() error {
 
 	c.Posts = posts
 
-	return writeToCache("posts.json", posts)
+	return writeToCache(c.config.DataDir, "posts.json", posts)
 }
 
-// FetchWeeklyUpdates fetches the comments on the last weekly project update threads.
-func (c *DataClient) FetchWeeklyUpdates() error {
-	result, err := c.bot.ListingWithParams("/r/TheRedditSymphony/search", map[string]string{
-		"restrict_sr": "1",
-		"sort":        "new",
-		"limit":       "3",
-		"q":           "Weekly Project Update Thread author:AutoModerator",
-	})
+// Weekly update threads are fetched back to the start of the oldest active
+// project, but at least weeklyUpdateMinThreads and at most
+// weeklyUpdateMaxThreads of them. Of the threads with cached comments, only
+// the newest weeklyUpdateRefreshThreads are fetched again.
+const (
+	weeklyUpdateMinThreads     = 3
+	weeklyUpdateMaxThreads     = 100
+	weeklyUpdateRefreshThreads = 3
+)
+
+// FetchWeeklyUpdates fetches the comments on the weekly project update
+// threads covering all active projects and merges them with the cached
+// comments of older threads, without duplicates. Threads that fail to load
+// are skipped with a warning, using their cached comments if possible. It
+// fails if no threads are found or if all fetched threads fail to load, so
+// that the cache isn't overwritten with less data. ctx is checked between
+// requests.
+func (c *DataClient) FetchWeeklyUpdates(ctx context.Context) error {
+	threads, err := c.findWeeklyUpdateThreads(ctx, c.oldestActiveProjectStart())
 	if err != nil {
 		return fmt.Errorf("fetching weekly update posts failed: %w", err)
 	}
+	if len(threads) == 0 {
+		return fmt.Errorf("fetching weekly update posts failed: no threads found for %q", c.config.WeeklyUpdateQuery)
+	}
+
+	var cached []reddit.Comment
+	if err := loadFromCache(c.config.DataDir, "weekly_updates.json", &cached); err != nil {
+		fmt.Printf("no cached weekly updates, fetching all threads: %s\n", err)
+	}
+	// Only top-level comments are stored, so the parent is the thread.
+	cachedByThread := make(map[string][]reddit.Comment)
+	for _, comment := range cached {
+		cachedByThread[comment.ParentID] = append(cachedByThread[comment.ParentID], comment)
+	}
 
 	var comments []reddit.Comment
-	for _, post := range result.Posts {
+	seen := make(map[string]bool)
+	add := func(comment reddit.Comment) {
+		if !seen[comment.ID] {
+			seen[comment.ID] = true
+			comments = append(comments, comment)
+		}
+	}
+	var fetched, failed int
+	var lastErr error
+	for i, post := range threads {
+		old, isCached := cachedByThread[post.Name]
+		if isCached && i >= weeklyUpdateRefreshThreads {
+			for _, comment := range old {
+				add(comment)
+			}
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("fetching comments for %s failed: %w", post.Title, err)
+		}
 		// Fetch comments.
+		fetched++
 		fullpost, err := c.bot.Thread(post.Permalink)
 		if err != nil {
-			return fmt.Errorf("fetching comments for %s failed: %w", post.Title, err)
+			failed++
+			lastErr = fmt.Errorf("fetching comments for %s failed: %w", post.Title, err)
+			if isCached {
+				c.Diagnostics.Warnf("using cached comments: fetching comments for %s failed: %s", post.Title, err)
+				for _, comment := range old {
+					add(comment)
+				}
+				continue
+			}
+			c.Diagnostics.Warnf("skipping weekly update thread: fetching comments for %s failed: %s", post.Title, err)
+			continue
 		}
 		for _, comment := range fullpost.Replies {
 			c := *comment
 			// We are only interested in top-level comments.
 			c.Replies = nil
-			comments = append(comments, c)
+			add(c)
 		}
 	}
+	if failed > 0 && failed == fetched {
+		return lastErr
+	}
 
 	c.WeeklyUpdates = comments
 
-	return writeToCache("weekly_updates.json", comments)
+	return writeToCache(c.config.DataDir, "weekly_updates.json", comments)
+}
+
+// findWeeklyUpdateThreads returns the weekly update threads back to the
+// first one before since, newest first.
+func (c *DataClient) findWeeklyUpdateThreads(ctx context.Context, since time.Time) ([]*reddit.Post, error) {
+	params := map[string]string{
+		"restrict_sr": "1",
+		"sort":        "new",
+		"limit":       "25",
+		"q":           c.config.WeeklyUpdateQuery,
+	}
+	var threads []*reddit.Post
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		result, err := c.bot.ListingWithParams(c.config.subredditPath("search"), params)
+		if err != nil {
+			return nil, err
+		}
+		for _, post := range result.Posts {
+			threads = append(threads, post)
+			// Updates in the first thread before since may be
+			// posted after since.
+			old := time.Unix(int64(post.CreatedUTC), 0).Before(since)
+			if old && len(threads) >= weeklyUpdateMinThreads || len(threads) == weeklyUpdateMaxThreads {
+				return threads, nil
+			}
+		}
+		if len(result.Posts) == 0 {
+			return threads, nil
+		}
+		params["after"] = result.Posts[len(result.Posts)-1].Name
+	}
+}
+
+// oldestActiveProjectStart returns the start of the oldest active project, or
+// the current time if there is none. If posts couldn't be fetched, the cached
+// ones are used.
+func (c *DataClient) oldestActiveProjectStart() time.Time {
+	posts := c.Posts
+	if len(posts) == 0 {
+		if err := loadFromCache(c.config.DataDir, "posts.json", &posts); err != nil {
+			fmt.Printf("no posts to find active projects: %s\n", err)
+		}
+	}
+	now := time.Now()
+	oldest := now
+	for i := range posts {
+		post := &posts[i]
+		if !c.config.isProject(post) {
+			continue
+		}
+		deadline := findDeadline(post.SelfText, int64(post.CreatedUTC))
+		if deadline.IsZero() || !isActive(deadline, now) {
+			continue
+		}
+		if start := time.Unix(int64(post.CreatedUTC), 0); start.Before(oldest) {
+			oldest = start
+		}
+	}
+	return oldest
 }
 
-// FetchVideos fetches the latest videos from YouTube.
-func (c *DataClient) FetchVideos() error {
+// uploadsSource is the source of videos from the uploads of the channel.
+const uploadsSource = "uploads"
+
+// FetchVideos fetches the latest videos from the playlists and the channel
+// uploads on YouTube with their details. Videos in several playlists are
+// only included once. Playlists that didn't change since the last fetch are
+// taken from the cache to save quota. If only the details can't be fetched,
+// the cached ones are used.
+func (c *DataClient) FetchVideos(ctx context.Context) error {
+	// Missing in the first run.
+	var prev videoCache
+	if err := loadFromCache(c.config.DataDir, "videos.json", &prev); err != nil {
+		fmt.Printf("no cached videos, fetching all playlists: %s\n", err)
+	}
+
+	type playlist struct{ source, id string }
+	playlists := []playlist{{c.config.PlaylistID, c.config.PlaylistID}}
+	for _, id := range c.config.ExtraPlaylistIDs {
+		playlists = append(playlists, playlist{id, id})
+	}
+	if c.config.ChannelID != "" {
+		uploads, err := c.fetchUploadsPlaylist(ctx)
+		if err != nil {
+			return err
+		}
+		playlists = append(playlists, playlist{uploadsSource, uploads})
+	}
+
 	var videos []youtube.PlaylistItem
-	call := c.youtube.PlaylistItems.List([]string{"snippet", "contentDetails"}).PlaylistId(rsoPlaylistID).MaxResults(50)
-	err := call.Pages(context.TODO(), func(res *youtube.PlaylistItemListResponse) error {
-		for _, item := range res.Items {
-			videos = append(videos, *item)
+	sources := make(map[string][]string)
+	cachedPlaylists := make(map[string]cachedPlaylist)
+	for _, pl := range playlists {
+		items, cached, err := c.fetchPlaylist(ctx, pl.id, &prev)
+		if err != nil {
+			return err
+		}
+		cachedPlaylists[pl.id] = cached
+		for _, item := range items {
+			id := item.ContentDetails.VideoId
+			s := sources[id]
+			if len(s) == 0 {
+				videos = append(videos, item)
+			}
+			if len(s) == 0 || s[len(s)-1] != pl.source {
+				sources[id] = append(s, pl.source)
+			}
 		}
-		return nil
-	})
-	if err != nil {
-		return err
 	}
 
 	sort.Slice(videos, func(i, j int) bool {
 		return videos[i].ContentDetails.VideoPublishedAt < videos[j].ContentDetails.VideoPublishedAt
 	})
 
+	details, err := c.fetchVideoDetails(ctx, videos)
+	if err != nil {
+		c.Diagnostics.Warnf("using cached video details: %s", err)
+		details = prev.Details
+	}
+
 	c.Videos = videos
+	c.VideoDetails = details
+	c.VideoSources = sources
+	for _, v := range c.videosMissingFromPlaylist() {
+		c.Diagnostics.Warnf("video %q (%s) was uploaded to the channel but is missing from playlist %s", v.Title, v.ID, c.config.PlaylistID)
+	}
 
-	return writeToCache("videos.json", videos)
+	return writeToCache(c.config.DataDir, "videos.json", videoCache{videos, details, sources, cachedPlaylists})
 }
 
-// FetchAllProjectsSheet fetches a CSV of the "All Projects" Google Sheet.
-func (c *DataClient) FetchAllProjectsSheet()  error {
-	resp, err := http.Get("https://docs.google.com/spreadsheets/d/"+allProjectsDoc+"/gviz/tq?tqx=out:csv")
-	if err != nil {
-		return err
+// fetchPlaylist fetches all items of a playlist. If the first page has the
+// same ETag and item count as in prev, the playlist didn't change and its
+// items are taken from prev.
+func (c *DataClient) fetchPlaylist(ctx context.Context, playlistID string, prev *videoCache) ([]youtube.PlaylistItem, cachedPlaylist, error) {
+	list := func() *youtube.PlaylistItemsListCall {
+		return c.youtube.PlaylistItems.List([]string{"snippet", "contentDetails"}).PlaylistId(playlistID).MaxResults(50).Context(ctx)
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("reading sheets CSV failed: %w", err)
+	// If the playlist changed, the first page of the conditional request is
+	// used as the first page of the full fetch.
+	var page *youtube.PlaylistItemListResponse
+	old, ok := prev.Playlists[playlistID]
+	if ok && time.Since(old.FetchedAt) < playlistMaxAge {
+		if items, ok := prev.items(old); ok {
+			first, err := list().IfNoneMatch(old.ETag).Do()
+			unchanged := googleapi.IsNotModified(err) ||
+				err == nil && first.Etag == old.ETag && first.PageInfo != nil && first.PageInfo.TotalResults == old.Count
+			if unchanged {
+				fmt.Printf("playlist %s is unchanged, using %d cached items\n", playlistID, len(items))
+				return items, old, nil
+			}
+			if err == nil {
+				page = first
+			}
+		}
 	}
 
-	// Remove lines before the main table.
-	lines := strings.Split(string(body), "\n")
-	i := 0
-	for !strings.Contains(lines[i], "Project Name") {
-		i++
+	var items []youtube.PlaylistItem
+	fetched := cachedPlaylist{FetchedAt: time.Now()}
+	for pageToken := ""; ; {
+		if page == nil {
+			call := list()
+			if pageToken != "" {
+				call = call.PageToken(pageToken)
+			}
+			var err error
+			if page, err = call.Do(); err != nil {
+				return nil, fetched, fmt.Errorf("fetching videos of playlist %s failed: %w", playlistID, err)
+			}
+		}
+		if fetched.ETag == "" {
+			fetched.ETag = page.Etag
+			if page.PageInfo != nil {
+				fetched.Count = page.PageInfo.TotalResults
+			}
+		}
+		for _, item := range page.Items {
+			items = append(items, *item)
+			fetched.VideoIDs = append(fetched.VideoIDs, item.ContentDetails.VideoId)
+		}
+		if page.NextPageToken == "" {
+			return items, fetched, nil
+		}
+		pageToken, page = page.NextPageToken, nil
 	}
-	csv := strings.Join(lines[i:], "\n")
+}
 
-	fname := "static/allprojects.tmp"
-	f, err := os.Create(fname)
+// fetchUploadsPlaylist returns the ID of the playlist with the uploads of
+// the channel.
+func (c *DataClient) fetchUploadsPlaylist(ctx context.Context) (string, error) {
+	res, err := c.youtube.Channels.List([]string{"contentDetails"}).Id(c.config.ChannelID).Context(ctx).Do()
 	if err != nil {
-		return fmt.Errorf("couldn't create %s: %w", fname, err)
+		return "", fmt.Errorf("fetching channel %s failed: %w", c.config.ChannelID, err)
 	}
-	defer f.Close()
+	if len(res.Items) == 0 || res.Items[0].ContentDetails == nil || res.Items[0].ContentDetails.RelatedPlaylists == nil {
+		return "", fmt.Errorf("channel %s not found", c.config.ChannelID)
+	}
+	return res.Items[0].ContentDetails.RelatedPlaylists.Uploads, nil
+}
 
-	if _, err = io.WriteString(f, csv); err != nil {
-		return fmt.Errorf("couldn't write %s: %w", fname, err)
+// videosMissingFromPlaylist returns the uploads of the channel that aren't
+// in the main playlist.
+func (c *DataClient) videosMissingFromPlaylist() []Video {
+	var missing []Video
+	for _, v := range c.videoList() {
+		if hasString(v.Sources, uploadsSource) && !hasString(v.Sources, c.config.PlaylistID) {
+			missing = append(missing, v)
+		}
 	}
+	return missing
+}
 
-	return os.Rename(fname, "static/allprojects.csv")
+func hasString(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
 }
 
-func writeToCache(name string, data interface{}) error {
-	if err := os.MkdirAll("data", 0777); err != nil {
-		return fmt.Errorf("couldn't create data directory: %w", err)
+// videosPerRequest is the maximum number of IDs for videos.list.
+const videosPerRequest = 50
+
+// fetchVideoDetails fetches statistics, durations and thumbnails of the
+// videos in batches.
+func (c *DataClient) fetchVideoDetails(ctx context.Context, items []youtube.PlaylistItem) ([]youtube.Video, error) {
+	var details []youtube.Video
+	for start := 0; start < len(items); start += videosPerRequest {
+		var ids []string
+		for i := start; i < len(items) && i < start+videosPerRequest; i++ {
+			ids = append(ids, items[i].ContentDetails.VideoId)
+		}
+		res, err := c.youtube.Videos.List([]string{"snippet", "contentDetails", "statistics"}).Id(ids...).Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("fetching video details failed: %w", err)
+		}
+		for _, v := range res.Items {
+			details = append(details, *v)
+		}
 	}
-	f, err := os.Create("data/" + name)
+	return details, nil
+}
+
+// FetchAllProjectsSheet fetches a CSV of the "All Projects" Google Sheet and
+// stores it in data/allprojects.csv. It fails if the table or required
+// columns can't be found, problems with single rows are reported as
+// warnings.
+func (c *DataClient) FetchAllProjectsSheet(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://docs.google.com/spreadsheets/d/"+c.config.AllProjectsSheetID+"/gviz/tq?tqx=out:csv", nil)
 	if err != nil {
-		return fmt.Errorf("couldn't create data/%s: %w", name, err)
+		return fmt.Errorf("fetching sheets CSV failed: %w", err)
 	}
-	defer f.Close()
-	encoder := json.NewEncoder(f)
-	encoder.SetIndent("", "  ")
-	if err = encoder.Encode(data); err != nil {
-		return fmt.Errorf("couldn't encode %s: %w", name, err)
+	resp, err := newAPIClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching sheets CSV failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching sheets CSV failed: %s", resp.Status)
 	}
-	return nil
-}
 
-func loadFromCache(name string, data interface{}) error {
-	f, err := os.Open("data/" + name)
+	rows, err := parseSheetCSV(resp.Body)
 	if err != nil {
-		return fmt.Errorf("couldn't load data/%s: %w", name, err)
+		return fmt.Errorf("parsing sheets CSV failed: %w", err)
 	}
-	defer f.Close()
-	decoder := json.NewDecoder(f)
-	if err = decoder.Decode(data); err != nil {
-		return fmt.Errorf("couldn't decode data/%s: %w", name, err)
+
+	// Remove rows before the main table.
+	header, err := findSheetHeader(rows)
+	if err != nil {
+		return fmt.Errorf("invalid sheet: %w", err)
 	}
-	return nil
+	rows = rows[header:]
+	_, rowErrs, err := parseSheet(rows, c.config.SheetCreatorAliases)
+	if err != nil {
+		return fmt.Errorf("invalid sheet: %w", err)
+	}
+	for _, err := range rowErrs {
+		c.Diagnostics.Warnf("sheet: %s", err)
+	}
+	c.Sheet = rows
+
+	return writeFileAtomic(filepath.Join(c.config.DataDir, "allprojects.csv"), func(w io.Writer) error {
+		return writeSheetCSV(w, rows)
+	})
 }
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Diagnostic is a problem found during a run that didn't stop it, e.g.
+// missing data that was replaced by an older value.
+type Diagnostic struct {
+	Time    string // RFC 3339
+	Message string
+}
+
+// Diagnostics collects the diagnostics of a run. Warnf is safe for
+// concurrent use.
+type Diagnostics struct {
+	mu       sync.Mutex
+	Warnings []Diagnostic
+}
+
+// Warnf records and prints a warning.
+func (d *Diagnostics) Warnf(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	fmt.Printf("warning: %s\n", msg)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Warnings = append(d.Warnings, Diagnostic{
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Message: msg,
+	})
+}
+
+// writeDiagnostics stores the diagnostics of the run in data/diagnostics.json.
+func writeDiagnostics(dir string, d *Diagnostics) error {
+	if d.Warnings == nil {
+		d.Warnings = []Diagnostic{}
+	}
+	return writeToCache(dir, "diagnostics.json", d)
+}
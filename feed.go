@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/turnage/graw/reddit"
+)
+
+// atomFeed is the root element of an Atom feed (RFC 4287).
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomPerson  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title    string       `xml:"title"`
+	ID       string       `xml:"id"`
+	Updated  string       `xml:"updated"`
+	Link     atomLink     `xml:"link"`
+	Author   *atomPerson  `xml:"author,omitempty"`
+	Category atomCategory `xml:"category"`
+	Summary  string       `xml:"summary,omitempty"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+// Example: **The deadline has been extended to December 5th!**
+var deadlineExtensionRegex = regexp.MustCompile(`(?i)\b(deadline (has been |is )?extended|extended deadline|deadline extension)\b`)
+
+// hasDeadlineExtension detects posts announcing a deadline extension.
+func hasDeadlineExtension(text string) bool {
+	return deadlineExtensionRegex.MatchString(text)
+}
+
+func redditTime(utc uint64) string {
+	return time.Unix(int64(utc), 0).UTC().Format(time.RFC3339)
+}
+
+func redditEntry(config *Config, post *reddit.Post, kind, title string) atomEntry {
+	return atomEntry{
+		// Titles are already escaped from the Reddit API.
+		Title:    html.UnescapeString(title),
+		ID:       config.feedIDPrefix() + "reddit/" + post.ID,
+		Updated:  redditTime(post.CreatedUTC),
+		Link:     atomLink{Href: "https://www.reddit.com" + post.Permalink},
+		Author:   &atomPerson{Name: "u/" + post.Author},
+		Category: atomCategory{Term: kind},
+	}
+}
+
+// buildFeed collects new projects, deadline extensions, released videos and
+// official news, newest first. Deadline extensions are dated by the time they
+// were first seen, from extensions by entry ID. New extensions are dated now
+// or, if extensions is nil because they are unknown, by the creation of the
+// post. It returns the times of the current extensions.
+func buildFeed(config *Config, client *DataClient, extensions map[string]string) (atomFeed, map[string]string) {
+	var entries []atomEntry
+	seen := make(map[string]string)
+
+	for _, post := range client.Posts {
+		switch {
+		case config.isProject(&post):
+			deadline := findDeadline(post.SelfText, int64(post.CreatedUTC))
+			if deadline.IsZero() {
+				continue
+			}
+			e := redditEntry(config, &post, "project", "New project: "+post.Title)
+			e.Summary = fmt.Sprintf("Deadline: %s", deadline.Format("2006-01-02"))
+			entries = append(entries, e)
+
+			if hasDeadlineExtension(post.SelfText) {
+				// The deadline is part of the ID so that every further
+				// extension shows up as a new entry.
+				e := redditEntry(config, &post, "extension", "Deadline extended: "+post.Title)
+				e.ID = fmt.Sprintf("%sreddit/%s/extension/%s", config.feedIDPrefix(), post.ID, deadline.Format("2006-01-02"))
+				e.Summary = fmt.Sprintf("New deadline: %s", deadline.Format("2006-01-02"))
+				// Extensions are announced by editing the post, but
+				// graw doesn't provide the edit time.
+				switch t, ok := extensions[e.ID]; {
+				case ok:
+					e.Updated = t
+				case extensions != nil:
+					e.Updated = client.now().UTC().Format(time.RFC3339)
+				}
+				seen[e.ID] = e.Updated
+				entries = append(entries, e)
+			}
+		case config.isNews(&post):
+			entries = append(entries, redditEntry(config, &post, "news", post.Title))
+		}
+	}
+
+	for _, v := range client.videoList() {
+		// Private videos don't have a publication date.
+		if v.Date == "" {
+			continue
+		}
+		entries = append(entries, atomEntry{
+			Title:    "New video: " + v.Title,
+			ID:       config.feedIDPrefix() + "youtube/" + v.ID,
+			Updated:  v.Date,
+			Link:     atomLink{Href: "https://youtu.be/" + v.ID},
+			Category: atomCategory{Term: "video"},
+		})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Updated > entries[j].Updated
+	})
+	if len(entries) > 50 {
+		entries = entries[:50]
+	}
+
+	feed := atomFeed{
+		Title: config.Title,
+		ID:    config.feedIDPrefix() + "feed",
+		Links: []atomLink{
+			{Href: config.SiteURL},
+			{Rel: "self", Type: "application/atom+xml", Href: config.SiteURL + "feed.atom"},
+		},
+		Author:  atomPerson{Name: "r/" + config.Subreddit},
+		Entries: entries,
+	}
+	if len(entries) > 0 {
+		feed.Updated = entries[0].Updated
+	} else {
+		feed.Updated = time.Now().UTC().Format(time.RFC3339)
+	}
+	return feed, seen
+}
+
+// writeFeed writes the Atom feed to static/feed.atom. It returns the times
+// the deadline extensions were first seen, see buildFeed.
+func writeFeed(out *OutputSet, config *Config, client *DataClient, extensions map[string]string) map[string]string {
+	feed, seen := buildFeed(config, client, extensions)
+	out.Write(config.staticFile("feed.atom"), func(w io.Writer) error {
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		encoder := xml.NewEncoder(w)
+		encoder.Indent("", "  ")
+		if err := encoder.Encode(feed); err != nil {
+			return fmt.Errorf("couldn't encode feed.atom: %w", err)
+		}
+		return nil
+	})
+	return seen
+}
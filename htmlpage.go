@@ -1,24 +1,30 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"html/template"
-	"os"
+	"io"
+	"regexp"
 	"sort"
+	"strconv"
 	"time"
 
 	"google.golang.org/api/youtube/v3"
 )
 
-// Project holds information on an ongoing RSO project.
+// Project holds information on an RSO project from a Reddit post or, for
+// older projects, from the "All Projects" sheet.
 type Project struct {
+	ID         string        // Reddit post ID, empty if FromSheet
 	Title      template.HTML // already escaped from the Reddit API
 	Organizer  string
 	URL        string
-	StartDate  string // ISO 8601
-	EndDate    string // ISO 8601
+	StartDate  string    // ISO 8601
+	EndDate    string    // ISO 8601
+	EndTime    time.Time // exact deadline if the post gives a time of day
 	IsOfficial bool
+	FromSheet  bool
+	SheetRow   int // if FromSheet
 
 	Registers             []string // sorted nicely
 	InstrumentsByRegister map[string][]Instrument
@@ -28,26 +34,131 @@ type Project struct {
 	LastUpdateDate      string
 	LastUpdatePermalink string
 
-	ReleasedVideo *Video
+	ReleasedVideo      *Video
+	ReleasedVideoMatch string // how ReleasedVideo was found, e.g. "title"
 }
 
-// Video holds information on a YouTube video.
+// Video holds information on a YouTube video. The details are zero if they
+// couldn't be fetched.
 type Video struct {
 	Title string
 	ID    string
 	Date  string // ISO 8601
+	// Sources are the playlists the video was found in, "uploads" for
+	// the uploads of the channel.
+	Sources []string
+
+	Duration     int // seconds
+	ViewCount    uint64
+	LikeCount    uint64
+	CommentCount uint64
+	Thumbnail    string // URL
 }
 
-func videoFromYT(v *youtube.PlaylistItem) Video {
-	return Video{
+// videoFromYT converts a playlist item and its details, which may be nil.
+func videoFromYT(v *youtube.PlaylistItem, details *youtube.Video) Video {
+	video := Video{
 		Title: v.Snippet.Title,
 		ID:    v.ContentDetails.VideoId,
 		Date:  v.ContentDetails.VideoPublishedAt,
 	}
+	if details != nil {
+		video.addDetails(details)
+	}
+	return video
+}
+
+// addDetails sets the statistics, duration and thumbnail from videos.list.
+func (v *Video) addDetails(details *youtube.Video) {
+	if details.ContentDetails != nil {
+		v.Duration = parseISODuration(details.ContentDetails.Duration)
+	}
+	if s := details.Statistics; s != nil {
+		v.ViewCount = s.ViewCount
+		v.LikeCount = s.LikeCount
+		v.CommentCount = s.CommentCount
+	}
+	if details.Snippet != nil && details.Snippet.Thumbnails != nil {
+		t := details.Snippet.Thumbnails
+		for _, thumb := range []*youtube.Thumbnail{t.High, t.Medium, t.Default} {
+			if thumb != nil {
+				v.Thumbnail = thumb.Url
+				break
+			}
+		}
+	}
+}
+
+var isoDurationRegexp = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseISODuration parses a YouTube duration like "PT4M13S" into seconds. It
+// returns 0 for invalid durations.
+func parseISODuration(s string) int {
+	m := isoDurationRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return 0
+	}
+	var secs int
+	for i, unit := range []int{24 * 3600, 3600, 60, 1} {
+		n, _ := strconv.Atoi(m[i+1])
+		secs += n * unit
+	}
+	return secs
+}
+
+// FormattedDuration returns the duration like "4:13" or "1:02:03".
+func (v *Video) FormattedDuration() string {
+	h, m, s := v.Duration/3600, v.Duration/60%60, v.Duration%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+// video converts a playlist item of the client with its details.
+func (c *DataClient) video(item *youtube.PlaylistItem) Video {
+	var details *youtube.Video
+	for i := range c.VideoDetails {
+		if c.VideoDetails[i].Id == item.ContentDetails.VideoId {
+			details = &c.VideoDetails[i]
+			break
+		}
+	}
+	v := videoFromYT(item, details)
+	v.Sources = c.VideoSources[v.ID]
+	return v
+}
+
+// videoList converts all videos of the client, oldest first.
+func (c *DataClient) videoList() []Video {
+	details := make(map[string]*youtube.Video)
+	for i := range c.VideoDetails {
+		details[c.VideoDetails[i].Id] = &c.VideoDetails[i]
+	}
+	videos := make([]Video, len(c.Videos))
+	for i := range c.Videos {
+		videos[i] = videoFromYT(&c.Videos[i], details[c.Videos[i].ContentDetails.VideoId])
+		videos[i].Sources = c.VideoSources[videos[i].ID]
+	}
+	return videos
+}
+
+// playlistVideos returns the videos found in the playlist. Videos without
+// sources are from before sources were recorded, when there only was the
+// playlist.
+func playlistVideos(videos []Video, playlistID string) []Video {
+	var found []Video
+	for _, v := range videos {
+		if len(v.Sources) == 0 || hasString(v.Sources, playlistID) {
+			found = append(found, v)
+		}
+	}
+	return found
 }
 
 // News holds information on an official news item.
 type News struct {
+	ID          string // Reddit post ID
 	Title       string
 	Author      string
 	Date        string
@@ -63,6 +174,37 @@ func (a ProjectsByEndDate) Len() int           { return len(a) }
 func (a ProjectsByEndDate) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ProjectsByEndDate) Less(i, j int) bool { return a[i].EndDate < a[j].EndDate }
 
+// lastGoodRender holds values shown on the index page that are carried
+// forward if a later run doesn't have them.
+type lastGoodRender struct {
+	LatestVideo *Video
+	VideoCount  int
+	News        []News
+}
+
+// numNews is the number of news items shown on the index page.
+const numNews = 5
+
+// numPopularVideos is the number of most viewed videos shown on the index
+// page.
+const numPopularVideos = 3
+
+// popularVideos returns the most viewed videos, skipping videos without
+// statistics.
+func popularVideos(videos []Video) []Video {
+	var popular []Video
+	for _, v := range videos {
+		if v.ViewCount > 0 {
+			popular = append(popular, v)
+		}
+	}
+	sort.SliceStable(popular, func(i, j int) bool { return popular[i].ViewCount > popular[j].ViewCount })
+	if len(popular) > numPopularVideos {
+		popular = popular[:numPopularVideos]
+	}
+	return popular
+}
+
 func instrumentsByRegister(instruments []Instrument) map[string][]Instrument {
 	m := make(map[string][]Instrument)
 	for _, instr := range instruments {
@@ -71,12 +213,14 @@ func instrumentsByRegister(instruments []Instrument) map[string][]Instrument {
 	return m
 }
 
-func createHTMLPage(client *DataClient) {
-	var allProjects, activeProjects []Project
+// findProjects finds all projects with a deadline and the subset of active
+// projects, both sorted by deadline. All projects include the finished
+// projects from the sheet.
+func findProjects(config *Config, client *DataClient) (allProjects, activeProjects []Project) {
 
 	// Find projects.
 	for _, post := range client.Posts {
-		if !isProject(&post) {
+		if !config.isProject(&post) {
 			continue
 		}
 		deadline := findDeadline(post.SelfText, int64(post.CreatedUTC))
@@ -91,24 +235,26 @@ func createHTMLPage(client *DataClient) {
 			}
 		}
 		p := Project{
+			ID:                    post.ID,
 			Title:                 template.HTML(post.Title),
 			Organizer:             post.Author,
 			URL:                   post.URL,
 			StartDate:             time.Unix(int64(post.CreatedUTC), 0).Format("2006-01-02"),
 			EndDate:               deadline.Format("2006-01-02"),
-			IsOfficial:            post.LinkFlairText == "Official Project",
+			IsOfficial:            post.LinkFlairText == config.OfficialProjectFlair,
 			Registers:             registers,
 			InstrumentsByRegister: byreg,
 			IsOpenInstrumentation: isOpenInstrumentation(post.SelfText),
 			Tags:                  findProjectTags(post.SelfText),
 		}
+		p.EndTime, _ = findDeadlineTime(post.SelfText, deadline)
 		if lastUpdate := findUpdateComment(&post, client.WeeklyUpdates); lastUpdate != nil {
 			p.LastUpdatePermalink = lastUpdate.Permalink
 			ts := lastUpdate.CreatedUTC
 			if lastUpdate.Edited > 0 {
 				ts = lastUpdate.Edited
 			}
-			diff := time.Now().Sub(time.Unix(int64(ts), 0)).Hours() / 24
+			diff := client.now().Sub(time.Unix(int64(ts), 0)).Hours() / 24
 			if diff < 1 {
 				p.LastUpdateDate = "today"
 			} else if diff < 2 {
@@ -118,12 +264,13 @@ func createHTMLPage(client *DataClient) {
 			}
 		}
 		if video := findMatchingVideo(&post, client.Videos, deadline); video != nil {
-			v := videoFromYT(video)
+			v := client.video(video)
 			p.ReleasedVideo = &v
+			p.ReleasedVideoMatch = "title"
 		}
 		allProjects = append(allProjects, p)
 		// Separate list with only active projects.
-		if time.Now().Sub(deadline).Hours() < 24+12 {
+		if isActive(deadline, client.now()) {
 			activeProjects = append(activeProjects, p)
 		}
 	}
@@ -131,17 +278,38 @@ func createHTMLPage(client *DataClient) {
 	sort.Sort(ProjectsByEndDate(allProjects))
 	sort.Sort(ProjectsByEndDate(activeProjects))
 
-	// Find videos.
-	latestVideo := videoFromYT(&client.Videos[len(client.Videos)-1])
+	if client.Sheet != nil {
+		// Problems with rows were reported when fetching the sheet.
+		sheetProjects, _, err := parseSheet(client.Sheet, config.SheetCreatorAliases)
+		if err != nil {
+			client.Diagnostics.Warnf("ignoring sheet: %s", err)
+			return
+		}
+		allProjects = mergeSheetProjects(allProjects, sheetProjects, client.videoList())
+	}
+	return
+}
+
+// createHTMLPage renders static/index.html and all other pages derived from
+// the fetched data and the projects found in it by findProjects.
+func createHTMLPage(config *Config, client *DataClient, allProjects, activeProjects []Project) error {
+	// Find videos. Only the main playlist has finished projects, the
+	// other sources may have trailers and announcements.
+	videos := client.videoList()
+	finished := playlistVideos(videos, config.PlaylistID)
+	var latestVideo *Video
+	if len(finished) > 0 {
+		latestVideo = &finished[len(finished)-1]
+	}
 
 	// Find news (flair Official).
 	var news []News
 	for _, post := range client.Posts {
-		// Only Official posts, skip weekly updates by AutoModerator.
-		if post.LinkFlairText != "Official" || post.Author == "AutoModerator" {
+		if !config.isNews(&post) {
 			continue
 		}
 		news = append(news, News{
+			ID:          post.ID,
 			Title:       post.Title,
 			Author:      post.Author,
 			Date:        time.Unix(int64(post.CreatedUTC), 0).Format("2006-01-02"),
@@ -151,44 +319,84 @@ func createHTMLPage(client *DataClient) {
 		})
 	}
 
-	tmpl, err := template.ParseFiles("template.html")
+	tmpl, err := template.ParseFiles(config.Template)
 	if err != nil {
-		fmt.Println(err)
-		return
+		return fmt.Errorf("parsing template failed: %w", err)
 	}
 
-	data := map[string]interface{}{
-		"Projects":    allProjects,
-		"LatestVideo": latestVideo,
-		"VideoCount":  len(client.Videos),
-		"Videos":      client.Videos,
-		"News":        news[0:5],
+	// Carry forward values from the last successful run if they are missing.
+	shown := lastGoodRender{latestVideo, len(finished), news}
+	if len(shown.News) > numNews {
+		shown.News = shown.News[:numNews]
 	}
-
-	jf, err := os.Create("static/projects.json")
-	if err != nil {
-		fmt.Println(err)
-		return
+	if latestVideo == nil || len(news) == 0 {
+		var last lastGoodRender
+		if err := loadFromCache(config.DataDir, "last_good_render.json", &last); err != nil {
+			client.Diagnostics.Warnf("no previous render to carry forward values from: %s", err)
+		}
+		if latestVideo == nil {
+			client.Diagnostics.Warnf("no videos, showing latest video from previous run")
+			shown.LatestVideo = last.LatestVideo
+			shown.VideoCount = last.VideoCount
+		}
+		if len(news) == 0 {
+			client.Diagnostics.Warnf("no news, showing news from previous run")
+			shown.News = last.News
+		}
+	}
+	if len(news) > 0 && len(news) < numNews {
+		client.Diagnostics.Warnf("only %d news items found", len(news))
 	}
-	defer jf.Close()
 
-	encoder := json.NewEncoder(jf)
-	if err = encoder.Encode(data); err != nil {
-		fmt.Println(fmt.Errorf("couldn't encode projects.json: %w", err))
-		return
+	data := map[string]interface{}{
+		"Projects":    activeProjects,
+		"LatestVideo": shown.LatestVideo,
+		"VideoCount":  shown.VideoCount,
+		"Videos":      videos,
+		"Popular":     popularVideos(finished),
+		"News":        shown.News,
+		"Stale":       client.Stale,
+		"Config":      config,
 	}
 
-	hf, err := os.Create("static/index.html")
-	if err != nil {
-		fmt.Println(err)
-		return
+	// Only publish the new files if all of them were written successfully.
+	var out OutputSet
+	out.Write(config.staticFile("projects.json"), func(w io.Writer) error {
+		doc := newAPIDocument(allProjects, videos, news)
+		for _, s := range client.Stale {
+			doc.Stale = append(doc.Stale, APIStaleSource{s.Name, s.Since.UTC().Format(time.RFC3339)})
+		}
+		return writeAPIDocument(w, doc)
+	})
+	out.Write(config.staticFile("index.html"), func(w io.Writer) error {
+		return tmpl.Execute(w, data)
+	})
+	if client.Sheet != nil {
+		// Not used by the pages anymore, kept as a download of the sheet
+		// as it was used for this render.
+		out.Write(config.staticFile("allprojects.csv"), func(w io.Writer) error {
+			return writeSheetCSV(w, client.Sheet)
+		})
+	}
+	writeDeadlineCalendars(&out, config, activeProjects)
+	// Times from later runs don't apply to snapshots.
+	var extensions map[string]string
+	if client.At.IsZero() {
+		if err := loadFromCache(config.DataDir, "feed_extensions.json", &extensions); err != nil {
+			client.Diagnostics.Warnf("no previous deadline extensions, dating them by their posts: %s", err)
+		}
+	}
+	extensions = writeFeed(&out, config, client, extensions)
+	if err = out.Publish(); err != nil {
+		return fmt.Errorf("publishing pages failed: %w", err)
 	}
-	defer hf.Close()
 
-	data["Projects"] = activeProjects
-	err = tmpl.Execute(hf, data)
-	if err != nil {
-		fmt.Println(err)
-		return
+	if !client.At.IsZero() {
+		// Don't carry forward values from the past.
+		return nil
+	}
+	if err := writeToCache(config.DataDir, "feed_extensions.json", extensions); err != nil {
+		return err
 	}
+	return writeToCache(config.DataDir, "last_good_render.json", shown)
 }
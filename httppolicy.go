@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// All requests to Reddit, YouTube and Google Sheets go through apiTransport,
+// which limits the request rate per host and retries failed requests.
+const (
+	maxRetries     = 4
+	initialBackoff = time.Second
+	maxBackoff     = 30 * time.Second
+	// attemptTimeout limits a single request, including reading the body.
+	attemptTimeout = 30 * time.Second
+)
+
+// hostIntervals is the minimum time between requests to a host.
+var hostIntervals = map[string]time.Duration{
+	"oauth.reddit.com": time.Second,
+	"www.reddit.com":   time.Second,
+	"docs.google.com":  time.Second,
+}
+
+const defaultHostInterval = 100 * time.Millisecond
+
+// hostLimiter spaces out requests to the same host.
+type hostLimiter struct {
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+// wait blocks until the next request to host is allowed.
+func (l *hostLimiter) wait(ctx context.Context, host string) error {
+	interval, ok := hostIntervals[host]
+	if !ok {
+		interval = defaultHostInterval
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	l.next[host] = at.Add(interval)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryTransport retries idempotent requests that failed with a network
+// error, 429 or 5xx with exponential backoff and jitter.
+type retryTransport struct {
+	base    http.RoundTripper
+	limiter hostLimiter
+}
+
+// apiTransport is shared by all API clients, so the rate limits apply to all
+// communities together.
+var apiTransport = &retryTransport{
+	base:    http.DefaultTransport,
+	limiter: hostLimiter{next: make(map[string]time.Time)},
+}
+
+// newAPIClient returns a client using apiTransport. Every client gets its own
+// http.Client as graw modifies it.
+func newAPIClient() *http.Client {
+	return &http.Client{Transport: apiTransport}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := t.limiter.wait(req.Context(), req.URL.Host); err != nil {
+			return nil, err
+		}
+		resp, err := t.roundTripOnce(req)
+		if attempt == maxRetries || !shouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		delay := backoff(attempt, resp)
+		reason := fmt.Sprint(err)
+		if resp != nil {
+			reason = resp.Status
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		fmt.Printf("%s %s failed (%s), retrying in %s\n", req.Method, req.URL.Host+req.URL.Path, reason, delay.Round(time.Millisecond))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// roundTripOnce does a single attempt with attemptTimeout.
+func (t *retryTransport) roundTripOnce(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), attemptTimeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{resp.Body, cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases the context of a request when its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// shouldRetry reports whether a request can and should be retried. Only
+// requests without side effects are retried, so e.g. a throwback isn't posted
+// twice.
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// backoff returns the delay before the next attempt. It respects the
+// Retry-After header, otherwise the delay doubles with each attempt, with
+// jitter to avoid retrying in lockstep.
+func backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs >= 0 {
+			if d := time.Duration(secs) * time.Second; d < maxBackoff {
+				return d
+			}
+			return maxBackoff
+		}
+	}
+	d := initialBackoff << uint(attempt)
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
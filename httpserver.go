@@ -0,0 +1,228 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// compressibleTypes are content type prefixes worth compressing.
+var compressibleTypes = []string{
+	"text/", "application/json", "application/javascript", "application/xml",
+	"application/atom+xml", "image/svg+xml",
+}
+
+// gzipResponseWriter compresses the response if the content type is
+// compressible.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	ctype := h.Get("Content-Type")
+	if code == http.StatusOK && h.Get("Content-Encoding") == "" {
+		for _, t := range compressibleTypes {
+			if strings.HasPrefix(ctype, t) {
+				h.Del("Content-Length")
+				h.Set("Content-Encoding", "gzip")
+				w.gz = gzip.NewWriter(w.ResponseWriter)
+				break
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *gzipResponseWriter) Close() error {
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+// withGzip compresses responses for clients that support it.
+func withGzip(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		// Byte ranges of the compressed response can't be served.
+		r.Header.Del("Range")
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		h.ServeHTTP(gw, r)
+	})
+}
+
+// cacheMaxAge returns how long browsers may cache a file from static/.
+// Generated files change with every run, the others only with a deploy.
+func cacheMaxAge(name string) time.Duration {
+	switch path.Ext(name) {
+	case ".html", ".json", ".atom", ".ics", ".csv", ".txt", "":
+		return 5 * time.Minute
+	default:
+		return 24 * time.Hour
+	}
+}
+
+// withCacheHeaders sets Cache-Control for static files.
+func withCacheHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path
+		if strings.HasSuffix(name, "/") {
+			name += "index.html"
+		}
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(cacheMaxAge(name).Seconds())))
+		h.ServeHTTP(w, r)
+	})
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := liveStatus.WriteMetrics(w, ""); err != nil {
+		fmt.Printf("writing metrics failed: %s\n", err)
+	}
+}
+
+var adminStatusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"ago": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return fmt.Sprintf("%s (%s ago)", t.UTC().Format(time.RFC3339), time.Since(t).Round(time.Second))
+	},
+}).Parse(`<!doctype html>
+<title>{{.Title}} Status</title>
+<meta charset="utf-8">
+<link rel="stylesheet" href="/rso.css">
+<main>
+	<div class="content-wrap">
+		<h1>{{.Title}} Status</h1>
+		{{range .Communities}}
+		<h2>{{.Community}}</h2>
+		<p>
+			Running since {{ago .Started}}.<br>
+			Last run: {{ago .LastRun}}{{with .LastFailed}}, <strong>failed in stage {{.Name}}</strong>{{end}}.<br>
+			{{.RunsTotal}} runs, {{.FailuresTotal}} failed.<br>
+			Fetched {{.PostsTotal}} posts, {{.WeeklyUpdatesTotal}} weekly update comments and {{.VideosTotal}} videos.
+			{{range .Sources}}{{if .Stale}}<br><strong>Using cached {{.Name}} from {{ago .Since}}.</strong>{{end}}{{end}}
+		</p>
+
+		<h3>Stages</h3>
+		<table>
+			<tr><th>Stage</th><th>Last success</th><th>Last error</th></tr>
+			{{range .Stages}}
+			<tr>
+				<td>{{.Name}}</td>
+				<td>{{ago .LastSuccess}}</td>
+				<td>{{if .LastError}}{{ago .LastErrorTime}}: {{.LastError}}{{end}}</td>
+			</tr>
+			{{end}}
+		</table>
+
+		<h3>Diagnostics</h3>
+		{{with .Diagnostics}}
+		<ul>
+			{{range .}}<li>{{.Time}}: {{.Message}}</li>{{end}}
+		</ul>
+		{{else}}
+		<p>No warnings in the last run.</p>
+		{{end}}
+		{{else}}
+		<p>No runs yet.</p>
+		{{end}}
+	</div>
+</main>
+`))
+
+// handleAdminStatus shows the status of all communities, titled after the
+// community served at /.
+func handleAdminStatus(title string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		data := map[string]interface{}{
+			"Title":       title,
+			"Communities": liveStatus.Snapshot(""),
+		}
+		if err := adminStatusTemplate.Execute(w, data); err != nil {
+			fmt.Printf("rendering status page failed: %s\n", err)
+		}
+	}
+}
+
+// newHTTPHandler serves static/, live metrics, the admin status page and the
+// refresh endpoint. The site of the first community is served at /, the
+// others at /<name>/.
+func newHTTPHandler(configs []*Config, ref *refresher) http.Handler {
+	mux := http.NewServeMux()
+	for i, config := range configs {
+		files := withCacheHeaders(http.FileServer(http.Dir(config.StaticDir)))
+		if i == 0 {
+			mux.Handle("/", files)
+			continue
+		}
+		prefix := "/" + config.Name
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, files))
+	}
+	mux.HandleFunc("/metrics", handleMetrics)
+	// The status page shows error messages of the APIs, so it needs the
+	// token as well.
+	mux.HandleFunc("/admin/status", requireToken(handleAdminStatus(configs[0].ShortTitle)))
+	mux.HandleFunc("/admin/refresh", requireToken(ref.handleRefresh))
+	return withGzip(mux)
+}
+
+// startHTTPServer starts serving on addr in the background.
+func startHTTPServer(configs []*Config, addr string, ref *refresher) *http.Server {
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      newHTTPHandler(configs, ref),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+	go func() {
+		fmt.Printf("serving HTTP on %s\n", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Printf("HTTP server failed: %s\n", err)
+		}
+	}()
+	return srv
+}
+
+// stopHTTPServer waits for running requests to finish.
+func stopHTTPServer(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		fmt.Printf("stopping HTTP server failed: %s\n", err)
+	}
+}
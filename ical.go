@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// icalEscaper escapes TEXT values (RFC 5545, section 3.3.11).
+var icalEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`)
+
+// icalLine writes a content line, folding it at 75 octets.
+func icalLine(w io.Writer, line string) {
+	for len(line) > 75 {
+		// Don't split UTF-8 sequences.
+		i := 75
+		for i > 0 && line[i]&0xC0 == 0x80 {
+			i--
+		}
+		fmt.Fprintf(w, "%s\r\n", line[:i])
+		line = " " + line[i:]
+	}
+	fmt.Fprintf(w, "%s\r\n", line)
+}
+
+// writeCalendar writes an iCalendar file with one event per project deadline,
+// at the exact time if it is known and all-day otherwise.
+func writeCalendar(w io.Writer, config *Config, name string, projects []Project) error {
+	var b strings.Builder
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	icalLine(&b, "BEGIN:VCALENDAR")
+	icalLine(&b, "VERSION:2.0")
+	icalLine(&b, fmt.Sprintf("PRODID:-//%s//%s Deadlines//EN", config.siteHost(), config.ShortTitle))
+	icalLine(&b, "X-WR-CALNAME:"+icalEscaper.Replace(name))
+	for _, p := range projects {
+		deadline, err := time.Parse("2006-01-02", p.EndDate)
+		if err != nil {
+			continue
+		}
+		url := "https://redd.it/" + p.ID
+		icalLine(&b, "BEGIN:VEVENT")
+		icalLine(&b, "UID:"+p.ID+"@"+config.siteHost())
+		icalLine(&b, "DTSTAMP:"+stamp)
+		if !p.EndTime.IsZero() {
+			// The deadline is a point in time.
+			t := p.EndTime.UTC().Format("20060102T150405Z")
+			icalLine(&b, "DTSTART:"+t)
+			icalLine(&b, "DTEND:"+t)
+		} else {
+			icalLine(&b, "DTSTART;VALUE=DATE:"+deadline.Format("20060102"))
+			icalLine(&b, "DTEND;VALUE=DATE:"+deadline.AddDate(0, 0, 1).Format("20060102"))
+		}
+		icalLine(&b, "SUMMARY:"+icalEscaper.Replace("Deadline: "+html.UnescapeString(string(p.Title))))
+		icalLine(&b, "DESCRIPTION:"+icalEscaper.Replace(fmt.Sprintf("Organized by u/%s\n%s", p.Organizer, url)))
+		icalLine(&b, "URL:"+url)
+		icalLine(&b, "END:VEVENT")
+	}
+	icalLine(&b, "END:VCALENDAR")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var nonSlugRegex = regexp.MustCompile(`[^a-z0-9]+`)
+
+// instrumentSlug turns an instrument name into a file name, e.g. "Eb Clarinet"
+// becomes "eb-clarinet".
+func instrumentSlug(name string) string {
+	return strings.Trim(nonSlugRegex.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// writeDeadlineCalendars writes static/deadlines.ics with all given projects
+// and static/deadlines/<instrument>.ics with only the projects that need the
+// instrument.
+func writeDeadlineCalendars(out *OutputSet, config *Config, projects []Project) {
+	out.Write(config.staticFile("deadlines.ics"), func(w io.Writer) error {
+		return writeCalendar(w, config, config.ShortTitle+" Deadlines", projects)
+	})
+
+	for _, instr := range instruments {
+		var filtered []Project
+	PROJECTS:
+		for _, p := range projects {
+			if p.IsOpenInstrumentation {
+				filtered = append(filtered, p)
+				continue
+			}
+			for _, i := range p.InstrumentsByRegister[instr.Register] {
+				if i.Name == instr.Name {
+					filtered = append(filtered, p)
+					continue PROJECTS
+				}
+			}
+		}
+		name := config.ShortTitle + " Deadlines: " + instr.Name
+		out.Write(config.staticFile("deadlines/"+instrumentSlug(instr.Name)+".ics"), func(w io.Writer) error {
+			return writeCalendar(w, config, name, filtered)
+		})
+	}
+}
@@ -1,130 +1,266 @@
 package main
 
 import (
-	"flag"
+	"context"
+	"errors"
 	"fmt"
-	"html/template"
+	"io"
 	"math/rand"
 	"os"
+	"sync"
 	"time"
-
-	"github.com/turnage/graw/reddit"
 )
 
-// isProject identifies projects by their flair.
-func isProject(post *reddit.Post) bool {
-	return post.LinkFlairText == "Approved Project" || post.LinkFlairText == "Official Project"
+// Stage is a step of a run. If it fails, the process exits with ExitCode.
+type Stage struct {
+	Name     string
+	ExitCode int
 }
 
-func printProjects(posts []reddit.Post) {
-	for i, project := range posts {
-		if isProject(&project) {
-			fmt.Printf("Project %d: %s\n%s\n", i, project.Title, project.URL)
-			fmt.Printf("Due: %s\n", findDeadline(project.SelfText, int64(project.CreatedUTC)).Format("2006-01-02"))
-			for _, instr := range findInstruments(project.SelfText) {
-				fmt.Printf(" - %s\n", instr.Name)
-			}
-		}
-	}
+var (
+	stageLoadCache          = Stage{"load_cache", 10}
+	stageInit               = Stage{"init", 11}
+	stageFetchPosts         = Stage{"fetch_posts", 12}
+	stageFetchWeeklyUpdates = Stage{"fetch_weekly_updates", 13}
+	stageFetchVideos        = Stage{"fetch_videos", 14}
+	stageFetchSheet         = Stage{"fetch_sheet", 15}
+	stageStore              = Stage{"store", 16}
+	stageRender             = Stage{"render", 20}
+	stageThrowback          = Stage{"throwback", 30}
+	stageMetrics            = Stage{"metrics", 40}
+)
+
+var allStages = []Stage{
+	stageLoadCache, stageInit, stageFetchPosts, stageFetchWeeklyUpdates,
+	stageFetchVideos, stageFetchSheet, stageStore, stageRender, stageThrowback, stageMetrics,
 }
 
-func printGanttChartData(posts []reddit.Post) {
-	for _, project := range posts {
-		if isProject(&project) {
-			title := project.Title
-			created := time.Unix(int64(project.CreatedUTC), 0).Format("2006-01-02")
-			deadline := findDeadline(project.SelfText, int64(project.CreatedUTC))
-			if !deadline.IsZero() {
-				fmt.Printf("%s\t%s\t%s\n", title, created, deadline.Format("2006-01-02"))
-			}
-		}
-	}
+// StageError is the error of a failed stage.
+type StageError struct {
+	Stage Stage
+	Err   error
 }
 
-var prometheusTemplate = template.Must(template.New("metrics.txt").Parse(`
-# HELP rso_last_run_seconds Timestamp of the last run
-# TYPE rso_last_run_seconds gauge
-rso_last_run_seconds {{.LastRunTS}}
+func (e *StageError) Error() string {
+	return fmt.Sprintf("%s failed: %s", e.Stage.Name, e.Err)
+}
 
-# HELP rso_data_items_total Number of items fetched from Reddit or YouTube
-# TYPE rso_data_items_total gauge
-rso_data_items_total{type="posts"} {{.PostsTotal}}
-rso_data_items_total{type="weekly_updates"} {{.WeeklyUpdatesTotal}}
-rso_data_items_total{type="videos"} {{.VideosTotal}}
-`))
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
 
-func writePrometheusStats(client *DataClient) error {
-	f, err := os.Create("static/metrics.tmp")
+// runStage records the result of a stage of a community and wraps errors in a
+// StageError.
+func runStage(community string, stage Stage, f func() error) error {
+	err := f()
+	liveStatus.stageDone(community, stage, err)
 	if err != nil {
-		return fmt.Errorf("creating static/metrics.tmp failed: %w", err)
+		return &StageError{stage, err}
 	}
-	err = prometheusTemplate.Execute(f, map[string]interface{}{
-		"LastRunTS":          time.Now().Unix(),
-		"PostsTotal":         len(client.Posts),
-		"WeeklyUpdatesTotal": len(client.WeeklyUpdates),
-		"VideosTotal":        len(client.Videos),
+	return nil
+}
+
+// writePrometheusStats writes the metrics of the community to
+// static/metrics.txt for the web server.
+func writePrometheusStats(config *Config) error {
+	return writeFileAtomic(config.staticFile("metrics.txt"), func(w io.Writer) error {
+		return liveStatus.WriteMetrics(w, config.Name)
 	})
-	f.Sync()
-	f.Close()
-	if err != nil {
-		return err
-	}
-	return os.Rename("static/metrics.tmp", "static/metrics.txt")
 }
 
 // https://www.reddit.com/r/TheRedditSymphony/search.json?restrict_sr=1&sort=new&q=flair:%22Approved%20Project%22&limit=100
 // next page with &count=100&after=xyz
 
-var cachedFlag = flag.Bool("cached", false, "use cached data")
-var throwbackFlag = flag.Bool("throwback", false, "post throwback link")
+// runOptions select what a run does.
+type runOptions struct {
+	cached    bool      // load data from data/ instead of fetching
+	at        time.Time // load data from the snapshot at this time instead
+	render    bool
+	throwback bool
+}
 
-func main() {
-	flag.Parse()
-	rand.Seed(time.Now().UnixNano())
+// fetchSources fetches the sources concurrently, each with its own timeout.
+// It returns the errors in the order of sources.
+func fetchSources(ctx context.Context, community string, sources []source) []error {
+	errs := make([]error, len(sources))
+	groups := make(map[string][]int)
+	var order []string
+	for i, src := range sources {
+		if _, ok := groups[src.group]; !ok {
+			order = append(order, src.group)
+		}
+		groups[src.group] = append(groups[src.group], i)
+	}
 
-	client := NewDataClient()
+	var wg sync.WaitGroup
+	for _, group := range order {
+		wg.Add(1)
+		go func(indexes []int) {
+			defer wg.Done()
+			for _, i := range indexes {
+				src := sources[i]
+				srcCtx, cancel := context.WithTimeout(ctx, src.timeout)
+				errs[i] = runStage(community, src.stage, func() error { return src.fetch(srcCtx) })
+				cancel()
+			}
+		}(groups[group])
+	}
+	wg.Wait()
+	return errs
+}
 
-	var err error
+// saveSnapshot stores the fetched data as snapshot and deletes old ones.
+// Failing to do so doesn't stop the run.
+func saveSnapshot(config *Config, client *DataClient) {
+	now := time.Now()
+	if err := writeSnapshot(config.DataDir, client, now); err != nil {
+		client.Diagnostics.Warnf("couldn't save snapshot: %s", err)
+		return
+	}
+	if err := pruneSnapshots(config.DataDir, config.SnapshotDays, now); err != nil {
+		client.Diagnostics.Warnf("couldn't delete old snapshots: %s", err)
+	}
+}
 
-	if *cachedFlag {
-		if err = client.LoadFromCache(); err != nil {
-			fmt.Printf("couldn't load from cache: %s\n", err)
-			return
+// run fetches data, renders the pages and optionally posts a throwback. If a
+// source can't be fetched, its cached copy is used instead and the run
+// continues; the error of the first such stage is returned at the end. The
+// same applies to storing the data in the database. Other failing stages stop
+// the run.
+func run(ctx context.Context, config *Config, client *DataClient, opts runOptions) error {
+	var fetchErr error
+	fetched := false
+	if !opts.at.IsZero() {
+		if err := runStage(config.Name, stageLoadCache, func() error { return client.LoadSnapshot(opts.at) }); err != nil {
+			return err
+		}
+	} else if opts.cached {
+		if err := runStage(config.Name, stageLoadCache, client.LoadFromCache); err != nil {
+			return err
 		}
 	} else {
-		if err = client.Init(); err != nil {
-			fmt.Printf("couldn't initialize data client: %s\n", err)
-			return
+		fetched = true
+		fetchErr = runStage(config.Name, stageInit, client.Init)
+		initErr := fetchErr
+		sources := client.sources()
+		errs := make([]error, len(sources))
+		if initErr == nil {
+			errs = fetchSources(ctx, config.Name, sources)
+		}
+		if err := ctx.Err(); err != nil {
+			// Canceled from outside, don't render with partial data.
+			return err
+		}
+		for i, src := range sources {
+			err := errs[i]
+			if initErr != nil {
+				err = initErr
+			}
+			if err == nil {
+				continue
+			}
+			if ferr := client.fallBackToCache(src, err); ferr != nil {
+				fmt.Println(ferr)
+				return err
+			}
+			if fetchErr == nil {
+				fetchErr = err
+			}
 		}
-		if err = client.FetchPosts(); err != nil {
-			fmt.Printf("couldn't fetch posts: %s\n", err)
-			return
+		if initErr != nil && opts.throwback {
+			// Posting needs Reddit.
+			opts.throwback = false
 		}
-		if err = client.FetchWeeklyUpdates(); err != nil {
-			fmt.Printf("couldn't fetch weekly updates: %s\n", err)
-			return
+		if config.SnapshotDays > 0 && len(client.Stale) < len(sources) {
+			saveSnapshot(config, client)
 		}
-		if err = client.FetchVideos(); err != nil {
-			fmt.Printf("couldn't fetch videos: %s\n", err)
-			return
+	}
+
+	// The projects are found once for the database and the pages, so that
+	// their warnings are only reported once.
+	store := fetched && config.Database != ""
+	var allProjects, activeProjects []Project
+	if store || opts.render {
+		allProjects, activeProjects = findProjects(config, client)
+	}
+
+	if store {
+		err := runStage(config.Name, stageStore, func() error { return saveToStore(config, client, allProjects) })
+		if err != nil && fetchErr == nil {
+			fetchErr = err
 		}
-		if err = client.FetchAllProjectsSheet(); err != nil {
-			fmt.Printf("couldn't fetch all projects sheet: %s\n", err)
-			return
+	}
+
+	if opts.render {
+		err := runStage(config.Name, stageRender, func() error {
+			return createHTMLPage(config, client, allProjects, activeProjects)
+		})
+		if err != nil {
+			return err
 		}
 	}
 
-	//printProjects(&search)
-	//printGanttChartData(&search)
-	createHTMLPage(client)
+	if opts.throwback {
+		if err := runStage(config.Name, stageThrowback, func() error { return postThrowback(config, client) }); err != nil {
+			return err
+		}
+	}
+	return fetchErr
+}
+
+// runOnce does a full run with a fresh client and writes diagnostics and
+// metrics. It returns the failed stage, if any.
+func runOnce(ctx context.Context, config *Config, opts runOptions) *Stage {
+	client := NewDataClient(config)
 
-	if *throwbackFlag {
-		if err = postThrowback(client); err != nil {
-			fmt.Printf("failed posting throwback: %s\n", err)
+	var failed *Stage
+	err := run(ctx, config, client, opts)
+	if err != nil {
+		fmt.Println(err)
+		var serr *StageError
+		if errors.As(err, &serr) {
+			failed = &serr.Stage
 		}
 	}
 
-	if err = writePrometheusStats(client); err != nil {
-		fmt.Printf("failed writing stats: %s\n", err)
+	// Keep the diagnostics, status and metrics of the latest run when
+	// rendering a snapshot.
+	if !opts.at.IsZero() {
+		return failed
 	}
+	if err := writeDiagnostics(config.DataDir, &client.Diagnostics); err != nil {
+		fmt.Printf("failed writing diagnostics: %s\n", err)
+	}
+
+	liveStatus.runDone(config.Name, client, failed)
+	if err := runStage(config.Name, stageMetrics, func() error { return writePrometheusStats(config) }); err != nil {
+		fmt.Println(err)
+		if failed == nil {
+			failed = &stageMetrics
+		}
+	}
+	return failed
+}
+
+// runAll does a full run for each community, one after the other. It returns
+// the first failed stage, if any.
+func runAll(ctx context.Context, configs []*Config, opts runOptions) *Stage {
+	var firstFailed *Stage
+	for _, config := range configs {
+		if len(configs) > 1 {
+			fmt.Printf("running for %s (r/%s)\n", config.Name, config.Subreddit)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if failed := runOnce(ctx, config, opts); failed != nil && firstFailed == nil {
+			firstFailed = failed
+		}
+	}
+	return firstFailed
+}
+
+func main() {
+	rand.Seed(time.Now().UnixNano())
+	os.Exit(runCommand(os.Args[1:]))
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// createTempFile writes a temporary file next to fname so that it can be
+// renamed atomically. The file is synced to disk before returning its name.
+func createTempFile(fname string, write func(w io.Writer) error) (string, error) {
+	dir, base := filepath.Split(fname)
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return "", fmt.Errorf("couldn't create directory %s: %w", dir, err)
+	}
+	f, err := os.CreateTemp(dir, "."+base+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("couldn't create temporary file for %s: %w", fname, err)
+	}
+	tmpname := f.Name()
+	err = write(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// CreateTemp uses mode 0600, but the web server needs to read our files.
+		err = os.Chmod(tmpname, 0644)
+	}
+	if err != nil {
+		os.Remove(tmpname)
+		return "", fmt.Errorf("couldn't write %s: %w", fname, err)
+	}
+	return tmpname, nil
+}
+
+// writeFileAtomic replaces fname with the output of write. Readers see either
+// the old or the new file, never a partially written one.
+func writeFileAtomic(fname string, write func(w io.Writer) error) error {
+	tmpname, err := createTempFile(fname, write)
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmpname, fname); err != nil {
+		os.Remove(tmpname)
+		return fmt.Errorf("couldn't replace %s: %w", fname, err)
+	}
+	return nil
+}
+
+// OutputSet collects a set of output files that are published together: if
+// writing any of them fails, none are replaced.
+type OutputSet struct {
+	files    []stagedFile
+	firstErr error
+}
+
+type stagedFile struct {
+	name, tmpname string
+}
+
+// Write stages a new version of fname. Errors are remembered and returned
+// by Publish.
+func (o *OutputSet) Write(fname string, write func(w io.Writer) error) {
+	if o.firstErr != nil {
+		return
+	}
+	tmpname, err := createTempFile(fname, write)
+	if err != nil {
+		o.firstErr = err
+		return
+	}
+	o.files = append(o.files, stagedFile{fname, tmpname})
+}
+
+// Publish renames all staged files to their final names if every write
+// succeeded. Otherwise, it discards the staged files and returns the first
+// error.
+func (o *OutputSet) Publish() error {
+	if o.firstErr != nil {
+		o.Discard()
+		return o.firstErr
+	}
+	for i, f := range o.files {
+		if err := os.Rename(f.tmpname, f.name); err != nil {
+			o.files = o.files[i:]
+			o.Discard()
+			return fmt.Errorf("couldn't replace %s: %w", f.name, err)
+		}
+	}
+	o.files = nil
+	return nil
+}
+
+// Discard removes all staged files.
+func (o *OutputSet) Discard() {
+	for _, f := range o.files {
+		os.Remove(f.tmpname)
+	}
+	o.files = nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
@@ -97,6 +98,65 @@ func findDeadline(text string, created int64) time.Time {
 	return t
 }
 
+// Example: The final date to submit is November 24th at 11:59 PM UTC.
+var deadlineTimeRegex = regexp.MustCompile(`(?i)^(?:st|nd|rd|th)?,?(?:\s*\d{4},?)?\s*(?:at|by|@|-)?\s*(\d{1,2})(?::(\d{2}))?(?:\s*([ap])\.?m\.?)?\s*\(?(UTC|GMT|[ECMP][SD]T|CES?T|BST)([+-]\d{1,2})?\b`)
+
+// timeZoneOffsets are the UTC offsets in hours of the time zones accepted in
+// deadlines.
+var timeZoneOffsets = map[string]int{
+	"UTC": 0, "GMT": 0, "BST": 1, "CET": 1, "CEST": 2,
+	"EST": -5, "EDT": -4, "CST": -6, "CDT": -5,
+	"MST": -7, "MDT": -6, "PST": -8, "PDT": -7,
+}
+
+// findDeadlineTime finds the time of day of the deadline found by
+// findDeadline, which must directly follow the date and include a time zone.
+// It returns the exact deadline in UTC and whether a time was found.
+func findDeadlineTime(text string, deadline time.Time) (time.Time, bool) {
+	loc := deadlineRegex.FindStringIndex(text)
+	if loc == nil {
+		return time.Time{}, false
+	}
+	rest := text[loc[1]:]
+	if i := strings.IndexByte(rest, '\n'); i >= 0 {
+		rest = rest[:i]
+	}
+	m := deadlineTimeRegex.FindStringSubmatch(rest)
+	if m == nil {
+		return time.Time{}, false
+	}
+	hour, _ := strconv.Atoi(m[1])
+	var minute int
+	if m[2] != "" {
+		minute, _ = strconv.Atoi(m[2])
+	}
+	if m[3] != "" {
+		if hour < 1 || hour > 12 {
+			return time.Time{}, false
+		}
+		hour %= 12
+		if strings.EqualFold(m[3], "p") {
+			hour += 12
+		}
+	}
+	if hour > 23 || minute > 59 {
+		return time.Time{}, false
+	}
+	offset := timeZoneOffsets[strings.ToUpper(m[4])]
+	if m[5] != "" {
+		extra, _ := strconv.Atoi(m[5])
+		offset += extra
+	}
+	zone := time.FixedZone(m[4]+m[5], offset*60*60)
+	return time.Date(deadline.Year(), deadline.Month(), deadline.Day(), hour, minute, 0, 0, zone).UTC(), true
+}
+
+// isActive reports whether a project with the deadline is still active at
+// now. Projects stay active until the deadline has passed everywhere.
+func isActive(deadline, now time.Time) bool {
+	return now.Sub(deadline).Hours() < 24+12
+}
+
 // findUpdateComment finds the latest update comment for the given project by matching author and URL.
 func findUpdateComment(post *reddit.Post, updates []reddit.Comment) *reddit.Comment {
 	for _, comment := range updates {
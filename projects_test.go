@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFindDeadlineTime(t *testing.T) {
+	created := time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC).Unix()
+	tests := []struct {
+		text string
+		want string // RFC 3339, empty if there is no exact time
+	}{
+		{"The final date to submit is November 24th.", ""},
+		{"The final date to submit is November 24th at 11:59 PM UTC.", "2021-11-24T23:59:00Z"},
+		{"Due date: Nov 24, 2021 23:59 GMT", "2021-11-24T23:59:00Z"},
+		{"Due date: Nov 24 at 5pm EST", "2021-11-24T22:00:00Z"},
+		{"Due date: Nov 24 at 5pm (UTC-5)", "2021-11-24T22:00:00Z"},
+		{"Due date: Nov 24 12am utc", "2021-11-24T00:00:00Z"},
+		// A time needs a time zone and must follow the date.
+		{"Due date: Nov 24 at 5pm", ""},
+		{"Due date: Nov 24th. 10 parts needed UTC", ""},
+		{"Due date: Nov 24\n11:00 UTC", ""},
+	}
+	for _, tt := range tests {
+		deadline := findDeadline(tt.text, created)
+		got, ok := findDeadlineTime(tt.text, deadline)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("findDeadlineTime(%q) = %s, want no time", tt.text, got.Format(time.RFC3339))
+			}
+			continue
+		}
+		if !ok || got.Format(time.RFC3339) != tt.want {
+			t.Errorf("findDeadlineTime(%q) = %s, %v, want %s", tt.text, got.Format(time.RFC3339), ok, tt.want)
+		}
+	}
+}
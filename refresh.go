@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minRefreshInterval is the minimum time between two requested refreshes.
+const minRefreshInterval = time.Minute
+
+// refresher coordinates on-demand refreshes with the serve loop. It is safe
+// for concurrent use.
+type refresher struct {
+	requests chan struct{}
+
+	mu        sync.Mutex
+	running   bool
+	lastStart time.Time
+}
+
+func newRefresher() *refresher {
+	// The buffer of one coalesces all requests arriving during a run.
+	return &refresher{requests: make(chan struct{}, 1)}
+}
+
+// RefreshStatus is the response of the refresh endpoint.
+type RefreshStatus struct {
+	Status    string // "queued", "already queued" or "debounced"
+	Running   bool
+	LastStart string `json:",omitempty"` // RFC 3339
+	RetryIn   string `json:",omitempty"`
+}
+
+// Request asks the serve loop for a refresh.
+func (r *refresher) Request() RefreshStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := RefreshStatus{Running: r.running}
+	if !r.lastStart.IsZero() {
+		status.LastStart = r.lastStart.UTC().Format(time.RFC3339)
+	}
+	if wait := minRefreshInterval - time.Since(r.lastStart); !r.running && wait > 0 {
+		status.Status = "debounced"
+		status.RetryIn = wait.Round(time.Second).String()
+		return status
+	}
+	select {
+	case r.requests <- struct{}{}:
+		status.Status = "queued"
+	default:
+		status.Status = "already queued"
+	}
+	return status
+}
+
+// run runs f and tracks it as the current run.
+func (r *refresher) run(f func()) {
+	r.mu.Lock()
+	r.running = true
+	r.lastStart = time.Now()
+	r.mu.Unlock()
+
+	f()
+
+	r.mu.Lock()
+	r.running = false
+	r.mu.Unlock()
+}
+
+// refreshToken returns the shared secret for the refresh endpoint from the
+// RSO_REFRESH_TOKEN environment variable.
+func refreshToken() string {
+	return os.Getenv("RSO_REFRESH_TOKEN")
+}
+
+// requestToken extracts the token from an "Authorization: Bearer" header.
+// Query parameters aren't accepted as they end up in access logs.
+func requestToken(req *http.Request) string {
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// requireToken protects the admin endpoints with the RSO_REFRESH_TOKEN. They
+// are disabled if that isn't set.
+func requireToken(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		token := refreshToken()
+		if token == "" {
+			http.Error(w, "admin endpoints disabled, set RSO_REFRESH_TOKEN", http.StatusNotFound)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(requestToken(req)), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		h(w, req)
+	}
+}
+
+// handleRefresh triggers a refresh. It must be wrapped with requireToken.
+func (r *refresher) handleRefresh(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := r.Request()
+	fmt.Printf("refresh requested from %s: %s\n", req.RemoteAddr, status.Status)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	switch status.Status {
+	case "debounced":
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(minRefreshInterval.Seconds())))
+		w.WriteHeader(http.StatusTooManyRequests)
+	default:
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(status)
+}
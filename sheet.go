@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Columns of the "All Projects" sheet.
+const (
+	sheetColTitle    = "Project Name"
+	sheetColCreator  = "Creator"
+	sheetColStart    = "Start Date"
+	sheetColDeadline = "Deadline"
+	sheetColLink     = "Links to Active Project Page OR Finished Result"
+)
+
+var sheetColumns = []string{sheetColTitle, sheetColCreator, sheetColStart, sheetColDeadline, sheetColLink}
+
+// SheetProject is a project from the "All Projects" sheet.
+type SheetProject struct {
+	Row       int // starting at 1 for the header
+	Title     string
+	Organizer string // Reddit user name
+	StartDate string // ISO 8601
+	EndDate   string // ISO 8601
+	VideoID   string // empty if there is no YouTube link
+}
+
+// findSheetHeader returns the index of the header row of the table. The
+// rows above it are notes.
+func findSheetHeader(rows [][]string) (int, error) {
+	for i, row := range rows {
+		for _, cell := range row {
+			if strings.TrimSpace(cell) == sheetColTitle {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no header row with column %q found in %d rows", sheetColTitle, len(rows))
+}
+
+// sheetColumnIndexes maps the required columns to their index in the header
+// row.
+func sheetColumnIndexes(header []string) (map[string]int, error) {
+	col := make(map[string]int)
+	for i, name := range header {
+		col[strings.TrimSpace(name)] = i
+	}
+	var missing []string
+	for _, name := range sheetColumns {
+		if _, ok := col[name]; !ok {
+			missing = append(missing, fmt.Sprintf("%q", name))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("sheet is missing columns %s", strings.Join(missing, ", "))
+	}
+	return col, nil
+}
+
+// parseSheet parses the rows of the sheet, starting with the header row. It
+// fails if required columns are missing. Rows that can't be parsed are
+// skipped and returned as rowErrs. Empty rows are ignored.
+func parseSheet(rows [][]string, creatorAliases map[string]string) (projects []SheetProject, rowErrs []error, err error) {
+	if len(rows) == 0 {
+		return nil, nil, fmt.Errorf("sheet is empty")
+	}
+	col, err := sheetColumnIndexes(rows[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, row := range rows[1:] {
+		if strings.TrimSpace(strings.Join(row, "")) == "" {
+			continue
+		}
+		get := func(name string) string {
+			if i := col[name]; i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		// Row numbers start at 1 for the header.
+		rowErr := func(format string, a ...interface{}) {
+			rowErrs = append(rowErrs, fmt.Errorf("row %d (%q): %s", i+2, get(sheetColTitle), fmt.Sprintf(format, a...)))
+		}
+		if get(sheetColTitle) == "" {
+			rowErr("no project name")
+			continue
+		}
+		start, err := parseSheetDate(get(sheetColStart))
+		if err != nil {
+			rowErr("start date: %s", err)
+			continue
+		}
+		end, err := parseSheetDate(get(sheetColDeadline))
+		if err != nil {
+			rowErr("deadline: %s", err)
+			continue
+		}
+		p := SheetProject{
+			Row:       i + 2,
+			Title:     get(sheetColTitle),
+			Organizer: sheetOrganizer(get(sheetColCreator), creatorAliases),
+			StartDate: start.Format("2006-01-02"),
+			EndDate:   end.Format("2006-01-02"),
+		}
+		if m := youtubeLinkRegexp.FindStringSubmatch(get(sheetColLink)); m != nil {
+			p.VideoID = m[2]
+		}
+		projects = append(projects, p)
+	}
+	return projects, rowErrs, nil
+}
+
+var (
+	sheetDateSuffixRegexp = regexp.MustCompile(`([0-9])([a-z]{2})?,\s*`)
+	sheetDateExtRegexp    = regexp.MustCompile(`\s*\(EXT\)`)
+	youtubeLinkRegexp     = regexp.MustCompile(`(youtu\.be/|youtube\.com/watch\?v=)([\w-]+)`)
+)
+
+// parseSheetDate parses a date like "October 9th, 2020". Extended deadlines
+// are marked with "(EXT)".
+func parseSheetDate(s string) (time.Time, error) {
+	normalized := sheetDateSuffixRegexp.ReplaceAllString(s, "$1 ")
+	normalized = strings.TrimSpace(sheetDateExtRegexp.ReplaceAllString(normalized, ""))
+	t, err := time.Parse("January 2 2006", normalized)
+	if err != nil {
+		return t, fmt.Errorf("invalid date %q", s)
+	}
+	return t, nil
+}
+
+// sheetOrganizer finds the Reddit user name in the creator column, e.g.
+// "u/someone (with friends)".
+func sheetOrganizer(creator string, aliases map[string]string) string {
+	for name, user := range aliases {
+		creator = strings.ReplaceAll(creator, name, user)
+	}
+	creator = strings.ReplaceAll(creator, "u/", "")
+	if i := strings.Index(creator, " "); i >= 0 {
+		creator = creator[:i]
+	}
+	return creator
+}
+
+// mergeSheetProjects adds released videos from the sheet to projects found
+// on Reddit and appends the finished projects only known from the sheet.
+// Only sheet projects with a video in the playlist are used. The result is
+// sorted by EndDate.
+func mergeSheetProjects(projects []Project, sheet []SheetProject, videos []Video) []Project {
+	videosByID := make(map[string]*Video)
+	for i := range videos {
+		videosByID[videos[i].ID] = &videos[i]
+	}
+
+	var released []SheetProject
+	byOrganizerAndStart := make(map[string]*SheetProject)
+	key := func(organizer, startDate string) string {
+		return strings.ToLower(organizer) + " - " + startDate
+	}
+	for _, sp := range sheet {
+		if videosByID[sp.VideoID] != nil {
+			released = append(released, sp)
+		}
+	}
+	for i := range released {
+		byOrganizerAndStart[key(released[i].Organizer, released[i].StartDate)] = &released[i]
+	}
+
+	// The sheet has the videos of projects found on Reddit.
+	knownVideos := make(map[string]bool)
+	for i := range projects {
+		p := &projects[i]
+		sp := byOrganizerAndStart[key(p.Organizer, p.StartDate)]
+		if sp == nil {
+			// Due to time zones, the start date is sometimes off by
+			// one.
+			if start, err := time.Parse("2006-01-02", p.StartDate); err == nil {
+				sp = byOrganizerAndStart[key(p.Organizer, start.AddDate(0, 0, -1).Format("2006-01-02"))]
+			}
+		}
+		if sp != nil {
+			v := *videosByID[sp.VideoID]
+			p.ReleasedVideo = &v
+			p.ReleasedVideoMatch = fmt.Sprintf("sheet row %d", sp.Row)
+		}
+		if p.ReleasedVideo != nil {
+			knownVideos[p.ReleasedVideo.ID] = true
+		}
+	}
+
+	// Older projects are only in the sheet.
+	for _, sp := range released {
+		if knownVideos[sp.VideoID] {
+			continue
+		}
+		v := *videosByID[sp.VideoID]
+		projects = append(projects, Project{
+			Title:              template.HTML(html.EscapeString(sp.Title)),
+			Organizer:          sp.Organizer,
+			StartDate:          sp.StartDate,
+			EndDate:            sp.EndDate,
+			FromSheet:          true,
+			SheetRow:           sp.Row,
+			ReleasedVideo:      &v,
+			ReleasedVideoMatch: fmt.Sprintf("sheet row %d", sp.Row),
+		})
+	}
+
+	sort.Stable(ProjectsByEndDate(projects))
+	return projects
+}
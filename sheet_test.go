@@ -0,0 +1,179 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testSheetHeader = []string{"Project Name", "Creator", "Start Date", "Deadline", "Links to Active Project Page OR Finished Result"}
+
+func TestFindSheetHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		rows [][]string
+		want int // -1 for an error
+	}{
+		{"first row", [][]string{testSheetHeader}, 0},
+		{"below notes", [][]string{{"Notes", ""}, {"", ""}, testSheetHeader}, 2},
+		{"padded", [][]string{{" Project Name ", "Creator"}}, 0},
+		{"no header", [][]string{{"Name", "Creator"}}, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findSheetHeader(tt.rows)
+			if tt.want < 0 {
+				if err == nil {
+					t.Fatalf("found header in row %d, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("header in row %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSheet(t *testing.T) {
+	aliases := map[string]string{"The Reddit Symphony Orchestra": "CasuallyNothing"}
+	tests := []struct {
+		name     string
+		rows     [][]string
+		want     []SheetProject
+		rowErrs  []string // expected parts of the row errors
+		errorMsg string   // expected part of the error
+	}{
+		{
+			name: "valid",
+			rows: [][]string{
+				testSheetHeader,
+				{"Symphony", "u/alice (with friends)", "October 9th, 2020", "November 24th, 2020 (EXT)", "https://youtu.be/abc-123"},
+				{"", "", "", "", ""},
+				{"Overture", "The Reddit Symphony Orchestra", "March 1st, 2021", "April 2nd, 2021", "https://reddit.com/r/x"},
+			},
+			want: []SheetProject{
+				{Row: 2, Title: "Symphony", Organizer: "alice", StartDate: "2020-10-09", EndDate: "2020-11-24", VideoID: "abc-123"},
+				{Row: 4, Title: "Overture", Organizer: "CasuallyNothing", StartDate: "2021-03-01", EndDate: "2021-04-02"},
+			},
+		},
+		{
+			name: "reordered columns",
+			rows: [][]string{
+				{"Deadline", "Links to Active Project Page OR Finished Result", "Creator", "Project Name", "Start Date", "Notes"},
+				{"May 5th, 2021", "https://www.youtube.com/watch?v=xyz", "bob", "Suite", "April 1st, 2021", "ignored"},
+			},
+			want: []SheetProject{
+				{Row: 2, Title: "Suite", Organizer: "bob", StartDate: "2021-04-01", EndDate: "2021-05-05", VideoID: "xyz"},
+			},
+		},
+		{
+			name: "bad rows are skipped",
+			rows: [][]string{
+				testSheetHeader,
+				{"Symphony", "alice", "October 9th, 2020", "November 24th, 2020", ""},
+				{"Broken", "bob", "sometime", "November 24th, 2020", ""},
+				{"Late", "carol", "October 1st, 2020", "TBD", ""},
+				{"", "dave", "October 1st, 2020", "November 1st, 2020", ""},
+				{"Short row", "erin", "October 1st, 2020"},
+			},
+			want: []SheetProject{
+				{Row: 2, Title: "Symphony", Organizer: "alice", StartDate: "2020-10-09", EndDate: "2020-11-24"},
+			},
+			rowErrs: []string{
+				`row 3 ("Broken"): start date: invalid date "sometime"`,
+				`row 4 ("Late"): deadline: invalid date "TBD"`,
+				`row 5 (""): no project name`,
+				`row 6 ("Short row"): deadline: invalid date ""`,
+			},
+		},
+		{
+			name:     "missing column",
+			rows:     [][]string{{"Project Name", "Creator", "Start Date"}},
+			errorMsg: `missing columns "Deadline", "Links to Active Project Page OR Finished Result"`,
+		},
+		{
+			name:     "empty",
+			errorMsg: "sheet is empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			projects, rowErrs, err := parseSheet(tt.rows, aliases)
+			if tt.errorMsg != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorMsg) {
+					t.Fatalf("error = %v, want %q", err, tt.errorMsg)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(projects, tt.want) {
+				t.Errorf("projects = %+v, want %+v", projects, tt.want)
+			}
+			if len(rowErrs) != len(tt.rowErrs) {
+				t.Fatalf("row errors = %v, want %d", rowErrs, len(tt.rowErrs))
+			}
+			for i, err := range rowErrs {
+				if !strings.Contains(err.Error(), tt.rowErrs[i]) {
+					t.Errorf("row error %d = %q, want %q", i, err, tt.rowErrs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMergeSheetProjects(t *testing.T) {
+	videos := []Video{{ID: "v1", Title: "Symphony"}, {ID: "v2", Title: "Overture"}, {ID: "v3", Title: "Suite"}, {ID: "v4", Title: "Elegy"}}
+	sheet := []SheetProject{
+		// Same project as on Reddit.
+		{Row: 2, Title: "Symphony", Organizer: "Alice", StartDate: "2021-01-01", EndDate: "2021-02-01", VideoID: "v1"},
+		// Start date is one day earlier than on Reddit.
+		{Row: 3, Title: "Suite", Organizer: "bob", StartDate: "2021-02-28", EndDate: "2021-04-01", VideoID: "v3"},
+		// Two days earlier is a different project, only in the sheet.
+		{Row: 4, Title: "Elegy", Organizer: "carol", StartDate: "2021-03-01", EndDate: "2021-03-15", VideoID: "v4"},
+		// Only in the sheet.
+		{Row: 5, Title: "Overture & Co", Organizer: "dave", StartDate: "2020-05-01", EndDate: "2020-06-01", VideoID: "v2"},
+		// The video isn't in the playlist.
+		{Row: 6, Title: "Unreleased", Organizer: "erin", StartDate: "2020-01-01", EndDate: "2020-02-01", VideoID: "missing"},
+		// No video.
+		{Row: 7, Title: "Active", Organizer: "frank", StartDate: "2021-05-01", EndDate: "2021-06-01"},
+	}
+	projects := []Project{
+		{ID: "r1", Title: "Symphony", Organizer: "alice", StartDate: "2021-01-01", EndDate: "2021-02-01"},
+		{ID: "r2", Title: "Suite", Organizer: "bob", StartDate: "2021-03-01", EndDate: "2021-04-01"},
+		{ID: "r3", Title: "Elegy", Organizer: "carol", StartDate: "2021-03-03", EndDate: "2021-04-01"},
+		{ID: "r4", Title: "Active", Organizer: "frank", StartDate: "2021-05-01", EndDate: "2021-06-01"},
+	}
+
+	merged := mergeSheetProjects(projects, sheet, videos)
+
+	type result struct {
+		ID, Title, EndDate, Video, Match string
+		FromSheet                        bool
+	}
+	var got []result
+	for _, p := range merged {
+		r := result{ID: p.ID, Title: string(p.Title), EndDate: p.EndDate, Match: p.ReleasedVideoMatch, FromSheet: p.FromSheet}
+		if p.ReleasedVideo != nil {
+			r.Video = p.ReleasedVideo.ID
+		}
+		got = append(got, r)
+	}
+	// Sorted by EndDate, projects with the same EndDate keep their order.
+	want := []result{
+		{"", "Overture &amp; Co", "2020-06-01", "v2", "sheet row 5", true},
+		{"r1", "Symphony", "2021-02-01", "v1", "sheet row 2", false},
+		{"", "Elegy", "2021-03-15", "v4", "sheet row 4", true},
+		{"r2", "Suite", "2021-04-01", "v3", "sheet row 3", false},
+		{"r3", "Elegy", "2021-04-01", "", "", false},
+		{"r4", "Active", "2021-06-01", "", "", false},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("merged projects:\n%+v\nwant\n%+v", got, want)
+	}
+}
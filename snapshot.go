@@ -0,0 +1,163 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/turnage/graw/reddit"
+	"google.golang.org/api/youtube/v3"
+)
+
+// snapshot is the data of a run, stored in data/snapshots/ to render the
+// site as it looked at an earlier time.
+type snapshot struct {
+	Time          time.Time
+	Posts         []reddit.Post
+	WeeklyUpdates []reddit.Comment
+	Videos        []youtube.PlaylistItem
+	VideoDetails  []youtube.Video
+	VideoSources  map[string][]string
+	Sheet         [][]string
+	Stale         []StaleSource
+}
+
+const (
+	snapshotDir        = "snapshots"
+	snapshotExt        = ".json.gz"
+	snapshotTimeFormat = "20060102T150405Z"
+	// snapshotKeepAllDays is how long all snapshots are kept. Older
+	// snapshots are thinned out to one per day.
+	snapshotKeepAllDays = 7
+)
+
+func snapshotPath(dataDir string, t time.Time) string {
+	return filepath.Join(dataDir, snapshotDir, t.UTC().Format(snapshotTimeFormat)+snapshotExt)
+}
+
+// writeSnapshot stores the data of the client as gzipped JSON.
+func writeSnapshot(dataDir string, client *DataClient, t time.Time) error {
+	if err := os.MkdirAll(filepath.Join(dataDir, snapshotDir), 0755); err != nil {
+		return fmt.Errorf("couldn't create snapshot directory: %w", err)
+	}
+	s := snapshot{
+		Time:          t.UTC(),
+		Posts:         client.Posts,
+		WeeklyUpdates: client.WeeklyUpdates,
+		Videos:        client.Videos,
+		VideoDetails:  client.VideoDetails,
+		VideoSources:  client.VideoSources,
+		Sheet:         client.Sheet,
+		Stale:         client.Stale,
+	}
+	return writeFileAtomic(snapshotPath(dataDir, t), func(w io.Writer) error {
+		gz := gzip.NewWriter(w)
+		if err := json.NewEncoder(gz).Encode(&s); err != nil {
+			return fmt.Errorf("couldn't encode snapshot: %w", err)
+		}
+		return gz.Close()
+	})
+}
+
+// listSnapshots returns the times of all snapshots, oldest first.
+func listSnapshots(dataDir string) ([]time.Time, error) {
+	entries, err := os.ReadDir(filepath.Join(dataDir, snapshotDir))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list snapshots: %w", err)
+	}
+	var times []time.Time
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, snapshotExt) {
+			continue
+		}
+		t, err := time.Parse(snapshotTimeFormat, strings.TrimSuffix(name, snapshotExt))
+		if err != nil {
+			continue
+		}
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times, nil
+}
+
+// LoadSnapshot populates the client from the latest snapshot taken at or
+// before at. Rendering then uses the time of the snapshot as current time.
+func (c *DataClient) LoadSnapshot(at time.Time) error {
+	times, err := listSnapshots(c.config.DataDir)
+	if err != nil {
+		return err
+	}
+	i := sort.Search(len(times), func(i int) bool { return times[i].After(at) })
+	if i == 0 {
+		return fmt.Errorf("no snapshot before %s", at.UTC().Format(time.RFC3339))
+	}
+
+	fname := snapshotPath(c.config.DataDir, times[i-1])
+	f, err := os.Open(fname)
+	if err != nil {
+		return fmt.Errorf("couldn't load snapshot: %w", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("couldn't decompress %s: %w", fname, err)
+	}
+	var s snapshot
+	if err := json.NewDecoder(gz).Decode(&s); err != nil {
+		return fmt.Errorf("couldn't decode %s: %w", fname, err)
+	}
+	fmt.Printf("rendering snapshot from %s\n", s.Time.Format(time.RFC3339))
+
+	c.Posts = s.Posts
+	c.WeeklyUpdates = s.WeeklyUpdates
+	c.Videos = s.Videos
+	c.VideoDetails = s.VideoDetails
+	c.VideoSources = s.VideoSources
+	c.Sheet = s.Sheet
+	c.Stale = s.Stale
+	c.At = s.Time
+	return nil
+}
+
+// pruneSnapshots deletes snapshots older than days. Of the snapshots older
+// than snapshotKeepAllDays, only the last one of each day is kept.
+func pruneSnapshots(dataDir string, days int, now time.Time) error {
+	times, err := listSnapshots(dataDir)
+	if err != nil {
+		return err
+	}
+	keepAllAfter := now.AddDate(0, 0, -snapshotKeepAllDays)
+	deleteBefore := now.AddDate(0, 0, -days)
+	day := func(t time.Time) string { return t.UTC().Format("2006-01-02") }
+	for i, t := range times {
+		remove := t.Before(deleteBefore) ||
+			(t.Before(keepAllAfter) && i+1 < len(times) && day(times[i+1]) == day(t))
+		if !remove {
+			continue
+		}
+		if err := os.Remove(snapshotPath(dataDir, t)); err != nil {
+			return fmt.Errorf("couldn't delete snapshot: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseSnapshotTime parses the argument of render -at, either a date (meaning
+// the end of that day in UTC) or an RFC 3339 time.
+func parseSnapshotTime(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t.AddDate(0, 0, 1).Add(-time.Second), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("expected a date like 2021-03-31 or an RFC 3339 time, got %q", s)
+	}
+	return t, nil
+}
@@ -18,15 +18,22 @@ function createTooltip(el) {
     .style("color", "black")
 }
 
+// escapeHTML escapes text for use in tooltips.
+function escapeHTML(s) {
+  return s.replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;')
+}
+
 function getTooltipContent(d) {
-  return `<strong>${d.Title}</strong>
+  return `<strong>${escapeHTML(d.Title)}</strong>
 <br/>
 <b style="color:${d.color.darker()}">${d.Organizer}</b>
 <br/>
 ${d.StartDate} - ${d.EndDate}
 `+(d.ReleasedVideo != null ? `<br/>
 Release: ${d.ReleasedVideo.Date.replace(/T.*/, '')}
-` : "")
+`+(d.ReleasedVideo.ViewCount ? `<br/>
+Views: ${d.ReleasedVideo.ViewCount.toLocaleString()}
+` : "") : "")
 }
 
 // countActiveAtDate returns the number of active projects at the given date.
@@ -266,7 +273,7 @@ function drawVideoReleases(videos) {
     .attr("viewBox", [0, 0, width, height])
 
   const hourOfVideo = v => {
-    let d = new Date(v.contentDetails.videoPublishedAt)
+    let d = new Date(v.Date)
     return d.getUTCHours() + d.getUTCMinutes() / 60
   }
 
@@ -277,14 +284,14 @@ function drawVideoReleases(videos) {
     .selectAll("a")
     .data(dodge(videos, {radius: radius * 2 + padding, x: d => x(hourOfVideo(d))}))
     .join("a")
-      .attr("href", d => `https://youtu.be/${d.data.contentDetails.videoId}`)
+      .attr("href", d => `https://youtu.be/${d.data.ID}`)
     .append("circle")
       .attr("cx", d => d.x)
       .attr("cy", d => height - margin.bottom - radius - padding - d.y)
       .attr("r", d => radius)
       .attr("fill", rso_mint)
     .append("title")
-      .text(d => `${d.data.snippet.title} (${d.data.contentDetails.videoPublishedAt.replace("T", " ").replace("Z", "")})`)
+      .text(d => `${d.data.Title} (${d.data.Date.replace("T", " ").replace("Z", "")})`)
         
 }
 
@@ -328,16 +335,16 @@ function drawChronologySlide(videos) {
 
   svg.append("g")
     .selectAll("a")
-    .data(videos.filter(d => d.contentDetails.videoPublishedAt < cutoff))
+    .data(videos.filter(d => d.Date < cutoff))
     .join("a")
-      .attr("href", d => `https://youtu.be/${d.contentDetails.videoId}`)
+      .attr("href", d => `https://youtu.be/${d.ID}`)
     .append("circle")
       .attr("cx", d => margin.left + Math.random() * (width - margin.left - margin.right))
-      .attr("cy", d => y(new Date(d.contentDetails.videoPublishedAt)))
+      .attr("cy", d => y(new Date(d.Date)))
       .attr("r", d => radius)
       .attr("fill", rso_mint)
     .append("title")
-      .text(d => `${d.snippet.title} (${d.contentDetails.videoPublishedAt.replace("T", " ").replace("Z", "")})`)
+      .text(d => `${d.Title} (${d.Date.replace("T", " ").replace("Z", "")})`)
         
 }
 // https://observablehq.com/@d3/beeswarm
@@ -386,78 +393,20 @@ function dodge(data, {radius = 1, x = d => d} = {}) {
   return circles;
 }
 
-// sheetDate converts a date like "October 9th, 2020" to ISO 8601.
-function sheetDate(d) {
-  const parse = d3.utcParse("%B %d %Y")
-  let normalized = d.replace(/([0-9])([a-z]{2})?,\s*/, '$1 ')
-                .replace(/\s*\(EXT\)/, '')
-                .trim()
-  let parsed = parse(normalized)
-  if (parsed == null) {
-    console.info(`invalid date: ${d} (${normalized})`)
-    return null
-  }
-  return toISODateString(parsed)
-}
-
-// dayBefore takes an ISO8601 date and returns the date of the day before.
-function dayBefore(d) {
-  let date = new Date(d)
-  let before = new Date(date.getTime() - 24 * 60 * 60 * 1000)
-  return toISODateString(before)
-}
-
 // toISODateString converts a Date to an ISO 8601 date string.
 function toISODateString(date) {
   return date.toISOString().slice(0, 10)
 }
 
 async function main() {
-  let [data, sheet] = await Promise.all([d3.json("projects.json"), d3.csv("allprojects.csv")])
-
-  let videosById = new Map(data.Videos.map(v => [v.contentDetails.videoId, v]))
-
-  let sheetProjects = sheet
-    .map(p => ({
-      FromSheet: true,
-      Title: p['Project Name'],
-      Organizer: p['Creator'].replace('The Reddit Symphony Orchestra', 'CasuallyNothing').replace(/u\/| .*/g, ''),
-      StartDate: sheetDate(p['Start Date']),
-      EndDate: sheetDate(p['Deadline']),
-      ReleasedVideo: (url => {
-        let m = /(youtu\.be\/|youtube\.com\/watch\?v=)([\w-]+)/.exec(url)
-        if (m == null) return null
-        let v = videosById.get(m[2])
-        if (v == null) {
-          console.info(`video https://youtu.be/${m[2]} for ${p['Project Name']} not in playlist`)
-          return null
-        }
-        return {
-          Title: v.snippet.title,
-          ID: v.contentDetails.videoId,
-          Date: v.contentDetails.videoPublishedAt,
-        }
-      })(p['Links to Active Project Page OR Finished Result']),
-    }))
-    .filter(p => p.StartDate != null && p.EndDate != null && p.ReleasedVideo != null)
-
-  // try to add video links by matching the organizer + start date
-  let toidx = p => `${p.Organizer.toLowerCase()} - ${p.StartDate}`
-  // hack: due to time zone issues, the start date is sometimes off-by-one
-  let toidx2 = p => `${p.Organizer.toLowerCase()} - ${dayBefore(p.StartDate)}`
-  let sheetProjectsIndex = new Map(sheetProjects.map(p => [toidx(p), p]))
-  data.Projects.forEach(p => {
-    let sp
-    if ((sp = sheetProjectsIndex.get(toidx(p))) || (sp = sheetProjectsIndex.get(toidx2(p)))) {
-      p.ReleasedVideo = sp.ReleasedVideo
-    }
-  })
-  // filter out projects we already know from Reddit posts
-  let projectsByVideoId = new Map(data.Projects.map(p => [p.ReleasedVideo?.ID, p]).filter(([k, v]) => k != null))
-  sheetProjects = sheetProjects.filter(p => !projectsByVideoId.get(p.ReleasedVideo.ID))
+  let data = await d3.json("projects.json")
+  if (data.Version != 2) {
+    console.error(`unsupported projects.json version ${data.Version}`)
+    return
+  }
 
-  const allProjects = data.Projects.concat(sheetProjects)
-  allProjects.sort((a, b) => d3.ascending(a.EndDate, b.EndDate))
+  // Projects include those from the sheet and are sorted by EndDate.
+  const allProjects = data.Projects
 
   let projects
 
@@ -466,6 +415,7 @@ async function main() {
     let key = by == 'deadline'  ? (p => p.EndDate) :
               by == 'video'     ? (p => p.ReleasedVideo?.Date ?? 'z'+p.EndDate) :
               by == 'organizer' ? (p => p.Organizer.toLowerCase()) :
+              by == 'views'     ? (p => -(p.ReleasedVideo?.ViewCount ?? -1)) :
               console.error("wrong sorby value", by)
     projects.sort((a, b) => d3.ascending(key(a), key(b)))
     drawChart(projects)
@@ -483,9 +433,9 @@ async function main() {
   applyFilter()
   d3.select("#timeline-showold").on("change", applyFilter)
 
-  drawVideoReleases(data.Videos.filter(v => v.contentDetails.videoPublishedAt))
+  drawVideoReleases(data.Videos.filter(v => v.Date))
 
-  drawChronologySlide(data.Videos.filter(v => v.contentDetails.videoPublishedAt))
+  drawChronologySlide(data.Videos.filter(v => v.Date))
 
 }
 
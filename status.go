@@ -0,0 +1,196 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"sync"
+	"time"
+)
+
+// StageStatus is the outcome of the latest runs of a stage.
+type StageStatus struct {
+	Name          string
+	LastSuccess   time.Time
+	LastError     string
+	LastErrorTime time.Time
+}
+
+// SourceStatus is the freshness of the data of a source in the last run.
+type SourceStatus struct {
+	Name  string
+	Stale bool      // loaded from the cache because fetching failed
+	Since time.Time // time of the cached data if stale
+}
+
+// StaleSeconds returns the age of the cached data, or 0 if it is fresh.
+func (s SourceStatus) StaleSeconds() int64 {
+	if !s.Stale {
+		return 0
+	}
+	return int64(time.Since(s.Since).Seconds())
+}
+
+// RunStatus is a snapshot of the state of all runs of a community since the
+// program started.
+type RunStatus struct {
+	Community     string
+	Started       time.Time
+	LastRun       time.Time
+	LastFailed    *Stage
+	RunsTotal     int
+	FailuresTotal int
+
+	PostsTotal         int
+	WeeklyUpdatesTotal int
+	VideosTotal        int
+
+	Sources     []SourceStatus // in the order of allSources
+	Stages      []StageStatus  // in the order of allStages
+	Diagnostics []Diagnostic   // of the last run
+}
+
+// statusTracker records the status of all runs for metrics and the admin
+// status page. It is safe for concurrent use.
+type statusTracker struct {
+	mu          sync.Mutex
+	started     time.Time
+	communities []*communityStatus // in the order of their first run
+}
+
+type communityStatus struct {
+	status RunStatus
+	stages map[string]*StageStatus
+}
+
+// liveStatus is the status of the current process.
+var liveStatus = newStatusTracker()
+
+func newStatusTracker() *statusTracker {
+	return &statusTracker{started: time.Now()}
+}
+
+func (t *statusTracker) community(name string) *communityStatus {
+	for _, c := range t.communities {
+		if c.status.Community == name {
+			return c
+		}
+	}
+	c := &communityStatus{
+		status: RunStatus{Community: name, Started: t.started},
+		stages: make(map[string]*StageStatus),
+	}
+	t.communities = append(t.communities, c)
+	return c
+}
+
+func (c *communityStatus) stage(name string) *StageStatus {
+	s, ok := c.stages[name]
+	if !ok {
+		s = &StageStatus{Name: name}
+		c.stages[name] = s
+	}
+	return s
+}
+
+// stageDone records the result of a stage.
+func (t *statusTracker) stageDone(community string, stage Stage, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.community(community).stage(stage.Name)
+	if err != nil {
+		s.LastError = err.Error()
+		s.LastErrorTime = time.Now()
+	} else {
+		s.LastSuccess = time.Now()
+	}
+}
+
+// runDone records the result of a full run.
+func (t *statusTracker) runDone(community string, client *DataClient, failed *Stage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := &t.community(community).status
+	s.LastRun = time.Now()
+	s.LastFailed = failed
+	s.RunsTotal++
+	if failed != nil {
+		s.FailuresTotal++
+	}
+	s.PostsTotal = len(client.Posts)
+	s.WeeklyUpdatesTotal = len(client.WeeklyUpdates)
+	s.VideosTotal = len(client.Videos)
+	s.Diagnostics = append([]Diagnostic(nil), client.Diagnostics.Warnings...)
+	s.Sources = nil
+	for _, name := range allSources {
+		src := SourceStatus{Name: name}
+		for _, stale := range client.Stale {
+			if stale.Name == name {
+				src.Stale = true
+				src.Since = stale.Since
+			}
+		}
+		s.Sources = append(s.Sources, src)
+	}
+}
+
+// Snapshot returns a copy of the current status of the given community, or
+// of all communities if community is empty.
+func (t *statusTracker) Snapshot(community string) []RunStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var result []RunStatus
+	for _, c := range t.communities {
+		if community != "" && c.status.Community != community {
+			continue
+		}
+		s := c.status
+		s.Stages = nil
+		for _, stage := range allStages {
+			s.Stages = append(s.Stages, *c.stage(stage.Name))
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+var prometheusTemplate = template.Must(template.New("metrics.txt").Parse(`
+# HELP rso_last_run_seconds Timestamp of the last run
+# TYPE rso_last_run_seconds gauge
+{{range .}}rso_last_run_seconds{community="{{.Community}}"} {{.LastRun.Unix}}
+{{end}}
+# HELP rso_runs_total Number of runs since the program started
+# TYPE rso_runs_total counter
+{{range .}}rso_runs_total{community="{{.Community}}"} {{.RunsTotal}}
+{{end}}
+# HELP rso_run_failures_total Number of failed runs since the program started
+# TYPE rso_run_failures_total counter
+{{range .}}rso_run_failures_total{community="{{.Community}}"} {{.FailuresTotal}}
+{{end}}
+# HELP rso_data_items_total Number of items fetched from Reddit or YouTube
+# TYPE rso_data_items_total gauge
+{{range .}}rso_data_items_total{community="{{.Community}}",type="posts"} {{.PostsTotal}}
+rso_data_items_total{community="{{.Community}}",type="weekly_updates"} {{.WeeklyUpdatesTotal}}
+rso_data_items_total{community="{{.Community}}",type="videos"} {{.VideosTotal}}
+{{end}}
+# HELP rso_data_stale_seconds Age of data loaded from the cache because fetching failed, 0 if fresh
+# TYPE rso_data_stale_seconds gauge
+{{range .}}{{$community := .Community}}{{range .Sources}}rso_data_stale_seconds{community="{{$community}}",source="{{.Name}}"} {{.StaleSeconds}}
+{{end}}{{end}}
+# HELP rso_diagnostics_total Number of warnings in the last run
+# TYPE rso_diagnostics_total gauge
+{{range .}}rso_diagnostics_total{community="{{.Community}}"} {{len .Diagnostics}}
+{{end}}
+# HELP rso_stage_failed Whether a stage failed in the last run
+# TYPE rso_stage_failed gauge
+{{range .}}{{$community := .Community}}{{$failed := .LastFailed}}{{range .Stages}}rso_stage_failed{community="{{$community}}",stage="{{.Name}}"} {{if and $failed (eq $failed.Name .Name)}}1{{else}}0{{end}}
+{{end}}{{end}}
+# HELP rso_stage_last_success_seconds Timestamp of the last successful run of a stage
+# TYPE rso_stage_last_success_seconds gauge
+{{range .}}{{$community := .Community}}{{range .Stages}}{{if not .LastSuccess.IsZero}}rso_stage_last_success_seconds{community="{{$community}}",stage="{{.Name}}"} {{.LastSuccess.Unix}}
+{{end}}{{end}}{{end}}`))
+
+// WriteMetrics writes the status of the given community (or all if empty) in
+// the Prometheus text format.
+func (t *statusTracker) WriteMetrics(w io.Writer, community string) error {
+	return prometheusTemplate.Execute(w, t.Snapshot(community))
+}
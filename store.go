@@ -0,0 +1,452 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/turnage/graw/reddit"
+	"google.golang.org/api/youtube/v3"
+
+	_ "modernc.org/sqlite" // pure Go, registers driver "sqlite"
+)
+
+// storeSchema is the schema of the SQLite store. Records are kept across
+// runs: first_run and last_run refer to the runs in which a record was seen
+// first and last, raw is the record as JSON. Position is the index in the
+// data of last_run, so records can be loaded in their original order.
+const storeSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id    INTEGER PRIMARY KEY AUTOINCREMENT,
+	time  TEXT NOT NULL, -- RFC 3339
+	stale TEXT NOT NULL  -- JSON list of the sources loaded from the cache
+);
+CREATE TABLE IF NOT EXISTS posts (
+	id        TEXT PRIMARY KEY,
+	author    TEXT NOT NULL,
+	title     TEXT NOT NULL,
+	flair     TEXT NOT NULL,
+	created   TEXT NOT NULL, -- RFC 3339
+	raw       TEXT NOT NULL,
+	position  INTEGER NOT NULL,
+	first_run INTEGER NOT NULL REFERENCES runs(id),
+	last_run  INTEGER NOT NULL REFERENCES runs(id)
+);
+CREATE TABLE IF NOT EXISTS comments (
+	id        TEXT PRIMARY KEY,
+	author    TEXT NOT NULL,
+	parent_id TEXT NOT NULL, -- weekly update thread
+	created   TEXT NOT NULL,
+	raw       TEXT NOT NULL,
+	position  INTEGER NOT NULL,
+	first_run INTEGER NOT NULL REFERENCES runs(id),
+	last_run  INTEGER NOT NULL REFERENCES runs(id)
+);
+CREATE TABLE IF NOT EXISTS videos (
+	id        TEXT PRIMARY KEY, -- YouTube video ID
+	title     TEXT NOT NULL,
+	published TEXT NOT NULL, -- empty for private videos
+	raw       TEXT NOT NULL,
+	position  INTEGER NOT NULL,
+	first_run INTEGER NOT NULL REFERENCES runs(id),
+	last_run  INTEGER NOT NULL REFERENCES runs(id)
+);
+CREATE TABLE IF NOT EXISTS video_stats (
+	id            TEXT PRIMARY KEY, -- YouTube video ID
+	duration      INTEGER NOT NULL, -- seconds
+	view_count    INTEGER NOT NULL,
+	like_count    INTEGER NOT NULL,
+	comment_count INTEGER NOT NULL,
+	thumbnail     TEXT NOT NULL,
+	raw           TEXT NOT NULL,
+	position      INTEGER NOT NULL,
+	first_run     INTEGER NOT NULL REFERENCES runs(id),
+	last_run      INTEGER NOT NULL REFERENCES runs(id)
+);
+CREATE TABLE IF NOT EXISTS video_sources (
+	video_id  TEXT NOT NULL,
+	source    TEXT NOT NULL, -- playlist ID or "uploads"
+	first_run INTEGER NOT NULL REFERENCES runs(id),
+	last_run  INTEGER NOT NULL REFERENCES runs(id),
+	PRIMARY KEY (video_id, source)
+);
+CREATE TABLE IF NOT EXISTS sheet_rows (
+	row   INTEGER PRIMARY KEY, -- starting at 1 for the header
+	cells TEXT NOT NULL,       -- JSON list
+	run   INTEGER NOT NULL REFERENCES runs(id)
+);
+CREATE TABLE IF NOT EXISTS projects (
+	key                  TEXT PRIMARY KEY, -- "reddit/<post ID>" or "sheet/<video ID>"
+	post_id              TEXT NOT NULL,
+	title                TEXT NOT NULL, -- plain text
+	organizer            TEXT NOT NULL,
+	start_date           TEXT NOT NULL,
+	end_date             TEXT NOT NULL,
+	is_official          INTEGER NOT NULL,
+	from_sheet           INTEGER NOT NULL,
+	instruments          TEXT NOT NULL, -- JSON list, empty for open instrumentation
+	open_instrumentation INTEGER NOT NULL,
+	tags                 TEXT NOT NULL, -- JSON list
+	released_video_id    TEXT NOT NULL,
+	-- Provenance: where the project was found and how its video was matched.
+	source               TEXT NOT NULL, -- "reddit" or "sheet"
+	sheet_row            INTEGER,
+	video_match          TEXT NOT NULL, -- e.g. "title" or "sheet row 12"
+	first_run            INTEGER NOT NULL REFERENCES runs(id),
+	last_run             INTEGER NOT NULL REFERENCES runs(id)
+);
+`
+
+// Store is the optional SQLite database holding the fetched records and the
+// projects derived from them.
+type Store struct {
+	db *sql.DB
+}
+
+// openStore opens the database at path, creating it if needed.
+func openStore(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(10000)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open database %s: %w", path, err)
+	}
+	if _, err := db.Exec(storeSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("couldn't create tables in %s: %w", path, err)
+	}
+	return &Store{db}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// upsert inserts a record seen in run or updates it, keeping first_run.
+func upsert(tx *sql.Tx, table string, run int64, cols []string, values ...interface{}) error {
+	cols = append(cols, "first_run", "last_run")
+	values = append(values, run, run)
+	var updates []string
+	for _, col := range cols[1:] {
+		if col != "first_run" {
+			updates = append(updates, col+" = excluded."+col)
+		}
+	}
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (?%s) ON CONFLICT (%s) DO UPDATE SET %s",
+		table, strings.Join(cols, ", "), strings.Repeat(", ?", len(cols)-1), cols[0], strings.Join(updates, ", "))
+	if _, err := tx.Exec(query, values...); err != nil {
+		return fmt.Errorf("couldn't store %s: %w", table, err)
+	}
+	return nil
+}
+
+func jsonString(v interface{}) string {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		// Only called with types that can be encoded.
+		panic(err)
+	}
+	return string(buf)
+}
+
+func unixRFC3339(ts uint64) string {
+	return time.Unix(int64(ts), 0).UTC().Format(time.RFC3339)
+}
+
+// SaveRun stores the data of the client and the projects derived from it as
+// a new run in one transaction.
+func (s *Store) SaveRun(client *DataClient, projects []Project, t time.Time) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("couldn't start transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	stale := client.Stale
+	if stale == nil {
+		stale = []StaleSource{}
+	}
+	res, err := tx.Exec("INSERT INTO runs (time, stale) VALUES (?, ?)", t.UTC().Format(time.RFC3339), jsonString(stale))
+	if err != nil {
+		return fmt.Errorf("couldn't store run: %w", err)
+	}
+	run, err := res.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("couldn't store run: %w", err)
+	}
+
+	for i, p := range client.Posts {
+		if err := upsert(tx, "posts", run, []string{"id", "author", "title", "flair", "created", "raw", "position"},
+			p.ID, p.Author, html.UnescapeString(p.Title), p.LinkFlairText, unixRFC3339(p.CreatedUTC), jsonString(p), i); err != nil {
+			return err
+		}
+	}
+	for i, c := range client.WeeklyUpdates {
+		if err := upsert(tx, "comments", run, []string{"id", "author", "parent_id", "created", "raw", "position"},
+			c.ID, c.Author, c.ParentID, unixRFC3339(c.CreatedUTC), jsonString(c), i); err != nil {
+			return err
+		}
+	}
+	for i, v := range client.Videos {
+		if err := upsert(tx, "videos", run, []string{"id", "title", "published", "raw", "position"},
+			v.ContentDetails.VideoId, v.Snippet.Title, v.ContentDetails.VideoPublishedAt, jsonString(v), i); err != nil {
+			return err
+		}
+	}
+
+	for i := range client.VideoDetails {
+		d := &client.VideoDetails[i]
+		var v Video
+		v.addDetails(d)
+		if err := upsert(tx, "video_stats", run, []string{"id", "duration", "view_count", "like_count", "comment_count", "thumbnail", "raw", "position"},
+			d.Id, v.Duration, v.ViewCount, v.LikeCount, v.CommentCount, v.Thumbnail, jsonString(d), i); err != nil {
+			return err
+		}
+	}
+
+	for id, sources := range client.VideoSources {
+		for _, source := range sources {
+			_, err := tx.Exec(`INSERT INTO video_sources (video_id, source, first_run, last_run) VALUES (?, ?, ?, ?)
+				ON CONFLICT (video_id, source) DO UPDATE SET last_run = excluded.last_run`, id, source, run, run)
+			if err != nil {
+				return fmt.Errorf("couldn't store video_sources: %w", err)
+			}
+		}
+	}
+
+	// The sheet is replaced as a whole, rows don't have stable IDs.
+	if client.Sheet != nil {
+		if _, err := tx.Exec("DELETE FROM sheet_rows"); err != nil {
+			return fmt.Errorf("couldn't replace sheet: %w", err)
+		}
+		for i, row := range client.Sheet {
+			if _, err := tx.Exec("INSERT INTO sheet_rows (row, cells, run) VALUES (?, ?, ?)", i+1, jsonString(row), run); err != nil {
+				return fmt.Errorf("couldn't store sheet: %w", err)
+			}
+		}
+	}
+
+	// Same order as projects.
+	apiProjects := newAPIDocument(projects, nil, nil).Projects
+	for i, p := range projects {
+		ap := apiProjects[i]
+		key, source := "reddit/"+p.ID, "reddit"
+		var sheetRow interface{}
+		if p.FromSheet {
+			key, source, sheetRow = "sheet/"+p.ReleasedVideo.ID, "sheet", p.SheetRow
+		}
+		instruments := ap.Instruments
+		if p.IsOpenInstrumentation {
+			instruments = []string{}
+		}
+		var videoID string
+		if p.ReleasedVideo != nil {
+			videoID = p.ReleasedVideo.ID
+		}
+		if err := upsert(tx, "projects", run, []string{"key", "post_id", "title", "organizer", "start_date", "end_date",
+			"is_official", "from_sheet", "instruments", "open_instrumentation", "tags", "released_video_id",
+			"source", "sheet_row", "video_match"},
+			key, p.ID, ap.Title, p.Organizer, p.StartDate, p.EndDate,
+			p.IsOfficial, p.FromSheet, jsonString(instruments), p.IsOpenInstrumentation, jsonString(ap.Tags), videoID,
+			source, sheetRow, p.ReleasedVideoMatch); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LoadLatest populates the client with the records of the latest run,
+// including the sources that were stale in it.
+func (s *Store) LoadLatest(client *DataClient) (err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("couldn't start transaction: %w", err)
+	}
+	// Only reads, so the transaction is just for a consistent view.
+	defer tx.Rollback()
+
+	var run int64
+	var staleJSON string
+	err = tx.QueryRow("SELECT id, stale FROM runs ORDER BY id DESC LIMIT 1").Scan(&run, &staleJSON)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("database has no runs yet")
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't find latest run: %w", err)
+	}
+	var stale []StaleSource
+	if err := json.Unmarshal([]byte(staleJSON), &stale); err != nil {
+		return fmt.Errorf("couldn't decode stale sources of run %d: %w", run, err)
+	}
+
+	var posts []reddit.Post
+	if err := loadRecords(tx, "posts", run, func() interface{} {
+		posts = append(posts, reddit.Post{})
+		return &posts[len(posts)-1]
+	}); err != nil {
+		return err
+	}
+	var comments []reddit.Comment
+	if err := loadRecords(tx, "comments", run, func() interface{} {
+		comments = append(comments, reddit.Comment{})
+		return &comments[len(comments)-1]
+	}); err != nil {
+		return err
+	}
+	var videos []youtube.PlaylistItem
+	if err := loadRecords(tx, "videos", run, func() interface{} {
+		videos = append(videos, youtube.PlaylistItem{})
+		return &videos[len(videos)-1]
+	}); err != nil {
+		return err
+	}
+
+	var details []youtube.Video
+	if err := loadRecords(tx, "video_stats", run, func() interface{} {
+		details = append(details, youtube.Video{})
+		return &details[len(details)-1]
+	}); err != nil {
+		return err
+	}
+
+	sources, err := loadVideoSources(tx, run)
+	if err != nil {
+		return err
+	}
+
+	rows, err := tx.Query("SELECT cells FROM sheet_rows ORDER BY row")
+	if err != nil {
+		return fmt.Errorf("couldn't load sheet: %w", err)
+	}
+	defer rows.Close()
+	var sheet [][]string
+	for rows.Next() {
+		var cells string
+		var row []string
+		if err := rows.Scan(&cells); err != nil {
+			return fmt.Errorf("couldn't load sheet: %w", err)
+		}
+		if err := json.Unmarshal([]byte(cells), &row); err != nil {
+			return fmt.Errorf("couldn't decode sheet row: %w", err)
+		}
+		sheet = append(sheet, row)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("couldn't load sheet: %w", err)
+	}
+
+	client.Posts = posts
+	client.WeeklyUpdates = comments
+	client.Videos = videos
+	client.VideoDetails = details
+	client.VideoSources = sources
+	client.Sheet = sheet
+	client.Stale = stale
+	return nil
+}
+
+// loadVideoSources loads the sources of the videos seen in run.
+func loadVideoSources(tx *sql.Tx, run int64) (map[string][]string, error) {
+	rows, err := tx.Query("SELECT video_id, source FROM video_sources WHERE last_run = ? ORDER BY rowid", run)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load video_sources: %w", err)
+	}
+	defer rows.Close()
+	sources := make(map[string][]string)
+	for rows.Next() {
+		var id, source string
+		if err := rows.Scan(&id, &source); err != nil {
+			return nil, fmt.Errorf("couldn't load video_sources: %w", err)
+		}
+		sources[id] = append(sources[id], source)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("couldn't load video_sources: %w", err)
+	}
+	return sources, nil
+}
+
+// loadRecords decodes the raw records of table seen in run, in their original
+// order, into the values returned by next.
+func loadRecords(tx *sql.Tx, table string, run int64, next func() interface{}) error {
+	rows, err := tx.Query("SELECT raw FROM "+table+" WHERE last_run = ? ORDER BY position", run)
+	if err != nil {
+		return fmt.Errorf("couldn't load %s: %w", table, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return fmt.Errorf("couldn't load %s: %w", table, err)
+		}
+		if err := json.Unmarshal([]byte(raw), next()); err != nil {
+			return fmt.Errorf("couldn't decode %s: %w", table, err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("couldn't load %s: %w", table, err)
+	}
+	return nil
+}
+
+// saveToStore stores the data of the run and the projects derived from it in
+// the database.
+func saveToStore(config *Config, client *DataClient, projects []Project) error {
+	store, err := openStore(config.Database)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return store.SaveRun(client, projects, time.Now())
+}
+
+// LoadFromStore populates the client from the latest run in the database.
+func (c *DataClient) LoadFromStore() error {
+	store, err := openStore(c.config.Database)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return store.LoadLatest(c)
+}
+
+// Query runs an ad-hoc query and writes the result as TSV with a header row.
+func (s *Store) Query(w io.Writer, query string) error {
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+	fmt.Fprintln(w, strings.Join(cols, "\t"))
+	values := make([]sql.NullString, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	fields := make([]string, len(cols))
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return fmt.Errorf("query failed: %w", err)
+		}
+		for i, v := range values {
+			// Tabs and newlines would break the TSV.
+			fields[i] = strings.NewReplacer("\t", " ", "\n", " ").Replace(v.String)
+		}
+		fmt.Fprintln(w, strings.Join(fields, "\t"))
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("query failed: %w", err)
+	}
+	return nil
+}
@@ -11,23 +11,32 @@ import (
 )
 
 // postThrowback posts a random video as "Thursday Throwback".
-func postThrowback(client *DataClient) error {
-	bot, err := reddit.NewBotFromAgentFile("agentfile", 1*time.Second)
+func postThrowback(config *Config, client *DataClient) error {
+	bot, err := newRedditBot(config.AgentFile)
 	if err != nil {
 		return fmt.Errorf("creating reddit bot failed: %w", err)
 	}
 
-	posts, err := fetchPreviousThrowbackPosts(bot)
+	posts, err := fetchPreviousThrowbackPosts(config, bot)
 	if err != nil {
 		return err
 	}
 
-	prevPostTime := time.Unix(int64(posts[0].CreatedUTC), 0)
-	if prevPostTime.AddDate(0, 0, 6).After(time.Now()) {
+	if len(posts) == 0 {
+		fmt.Println("no previous throwback post found")
+	} else if prevPostTime := time.Unix(int64(posts[0].CreatedUTC), 0); prevPostTime.AddDate(0, 0, 6).After(time.Now()) {
 		return fmt.Errorf("previous throwback post too young (%s)", prevPostTime.Format(time.RFC3339))
 	}
 
-	video, err := chooseThrowbackVideo(client.Videos, posts)
+	// Only finished projects from the main playlist, no trailers.
+	var videos []youtube.PlaylistItem
+	for _, v := range client.Videos {
+		sources := client.VideoSources[v.ContentDetails.VideoId]
+		if len(sources) == 0 || hasString(sources, config.PlaylistID) {
+			videos = append(videos, v)
+		}
+	}
+	video, err := chooseThrowbackVideo(videos, posts)
 	if err != nil {
 		return fmt.Errorf("couldn't choose video: %w", err)
 	}
@@ -36,7 +45,7 @@ func postThrowback(client *DataClient) error {
 	url := fmt.Sprintf("https://youtu.be/%s", video.ContentDetails.VideoId)
 	fmt.Printf("%s <%s>\n", title, url)
 
-	if err = bot.PostLink("TheRedditSymphony", title, url); err != nil {
+	if err = bot.PostLink(config.Subreddit, title, url); err != nil {
 		return fmt.Errorf("couldn't post throwback link: %w", err)
 	}
 
@@ -44,12 +53,12 @@ func postThrowback(client *DataClient) error {
 }
 
 // fetchPreviousThrowbackPosts fetches previous posts flaired "Throwback Thursday".
-func fetchPreviousThrowbackPosts(bot reddit.Scanner) ([]*reddit.Post, error) {
-	results, err := bot.ListingWithParams("/r/TheRedditSymphony/search", map[string]string{
+func fetchPreviousThrowbackPosts(config *Config, bot reddit.Scanner) ([]*reddit.Post, error) {
+	results, err := bot.ListingWithParams(config.subredditPath("search"), map[string]string{
 		"restrict_sr": "1",
 		"sort":        "new",
 		"limit":       "10",
-		"q":           "author:rso-throwback OR flair:\"Throwback Thursday\"",
+		"q":           fmt.Sprintf("author:%s OR flair:%q", config.ThrowbackAuthor, config.ThrowbackFlair),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("fetching throwback posts failed: %w", err)
@@ -57,12 +66,16 @@ func fetchPreviousThrowbackPosts(bot reddit.Scanner) ([]*reddit.Post, error) {
 	return results.Posts, nil
 }
 
-// chooseThrowbackVideo (intelligently) chooses a random throwback video.
+// chooseThrowbackVideo (intelligently) chooses a random throwback video. It
+// returns an error if none of the tried videos qualifies.
 func chooseThrowbackVideo(videos []youtube.PlaylistItem, posts []*reddit.Post) (*youtube.PlaylistItem, error) {
-	var v youtube.PlaylistItem
+	if len(videos) == 0 {
+		return nil, fmt.Errorf("no videos")
+	}
+	attempts := len(videos)/2 + 1
 CHOOSE:
-	for i := 0; i < len(videos)/2; i++ {
-		v = videos[rand.Intn(len(videos))]
+	for i := 0; i < attempts; i++ {
+		v := videos[rand.Intn(len(videos))]
 
 		// Skip private videos.
 		if v.ContentDetails.VideoPublishedAt == "" {
@@ -85,7 +98,7 @@ CHOOSE:
 		}
 
 		// video should be good
-		break
+		return &v, nil
 	}
-	return &v, nil
+	return nil, fmt.Errorf("no suitable video found in %d attempts", attempts)
 }
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/turnage/graw/reddit"
+	"google.golang.org/api/youtube/v3"
+)
+
+func throwbackTestVideo(id string, published time.Time) youtube.PlaylistItem {
+	item := youtube.PlaylistItem{
+		Snippet:        &youtube.PlaylistItemSnippet{Title: id},
+		ContentDetails: &youtube.PlaylistItemContentDetails{VideoId: id},
+	}
+	if !published.IsZero() {
+		item.ContentDetails.VideoPublishedAt = published.Format(time.RFC3339)
+	}
+	return item
+}
+
+func TestChooseThrowbackVideo(t *testing.T) {
+	old := time.Now().AddDate(-1, 0, 0)
+	recent := time.Now().AddDate(0, 0, -3)
+	tests := []struct {
+		name   string
+		videos []youtube.PlaylistItem
+		posts  []*reddit.Post
+		want   string // video ID, empty for an error
+	}{
+		{"no videos", nil, nil, ""},
+		{"one old video", []youtube.PlaylistItem{throwbackTestVideo("a", old)}, nil, "a"},
+		{"only recent and private videos", []youtube.PlaylistItem{throwbackTestVideo("a", recent), throwbackTestVideo("b", time.Time{})}, nil, ""},
+		{"already posted", []youtube.PlaylistItem{throwbackTestVideo("a", old)}, []*reddit.Post{{URL: "https://youtu.be/a"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			video, err := chooseThrowbackVideo(tt.videos, tt.posts)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("chose %s, want an error", video.ContentDetails.VideoId)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if video.ContentDetails.VideoId != tt.want {
+				t.Errorf("chose %s, want %s", video.ContentDetails.VideoId, tt.want)
+			}
+		})
+	}
+}